@@ -10,12 +10,14 @@ import (
 
 	"github.com/whookdev/conductor/internal/conductor"
 	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/postgres"
 	"github.com/whookdev/conductor/internal/redis"
+	"github.com/whookdev/conductor/internal/requestid"
 	"github.com/whookdev/conductor/internal/server"
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.New(requestid.NewHandler(slog.NewJSONHandler(os.Stdout, nil)))
 	slog.SetDefault(logger)
 
 	if err := initiateApp(logger); err != nil {
@@ -55,14 +57,25 @@ func initiateApp(logger *slog.Logger) error {
 		return nil
 	}()
 
+	pg, err := postgres.New(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("creating postgres client: %w", err)
+	}
+
+	if err := pg.Start(ctx); err != nil {
+		return fmt.Errorf("reaching postgres server: %w", err)
+	}
+	defer pg.Stop()
+
 	c, err := conductor.New(cfg, rdb.Client, logger)
 	if err != nil {
 		return fmt.Errorf("creating coordinator: %w", err)
 	}
 
 	c.StartCleanupRoutine(ctx)
+	c.StartAssignmentEvents(ctx)
 
-	srv, err := server.New(cfg, c, logger)
+	srv, err := server.New(cfg, c, rdb.Client, pg.Pool, logger)
 	if err != nil {
 		return fmt.Errorf("creating HTTP server: %w", err)
 	}
@@ -0,0 +1,42 @@
+package clientip
+
+import (
+	"net"
+	"net/netip"
+)
+
+// FromRemoteAddr parses the address portion of an http.Request RemoteAddr.
+func FromRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// Trusted reports whether the peer at remoteAddr is one of the configured
+// proxies, and so whether its forwarding headers can be believed.
+func Trusted(prefixes []netip.Prefix, remoteAddr string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+
+	addr, ok := FromRemoteAddr(remoteAddr)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"math"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,17 +16,53 @@ import (
 	"github.com/whookdev/conductor/internal/models"
 )
 
+const (
+	// staleHeartbeat is how long a relay can go without a heartbeat before
+	// projects stop being assigned to it.
+	staleHeartbeat = 30 * time.Second
+	// deadRelayTimeout is how long a relay can go without a heartbeat before
+	// it is removed and its projects are moved.
+	deadRelayTimeout = 60 * time.Second
+	// cleanupInterval is how often relays are checked, short enough that
+	// watchers hear a relay has gone stale soon after it has.
+	cleanupInterval = 10 * time.Second
+)
+
 type Conductor struct {
 	cfg    *config.Config
 	rdb    *redis.Client
 	logger *slog.Logger
+
+	watchMu  sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+
+	// staleRelays holds the last heartbeat of each relay announced as stale,
+	// so each stale spell is announced once. Only the cleanup routine uses
+	// it.
+	staleRelays map[string]time.Time
 }
 
+// Protocols a relay can advertise. Relays that advertise nothing are assumed
+// to speak HTTP/1.1, and HTTP/2 when it is negotiated over TLS.
+const (
+	ProtocolHTTP1 = "http/1.1"
+	ProtocolH2    = "h2"
+	ProtocolH2C   = "h2c"
+)
+
 type ServerInfo struct {
-	LastHeartbeat time.Time `json:"last_heartbeat"`
-	Load          int       `json:"load"`
-	RelayUrl      string    `json:"relay_url"`
-	RelayWSUrl    string    `json:"relay_ws_url"`
+	ID              string    `json:"-"`
+	LastHeartbeat   time.Time `json:"last_heartbeat"`
+	Load            int       `json:"load"`
+	RelayUrl        string    `json:"relay_url"`
+	RelayWSUrl      string    `json:"relay_ws_url"`
+	CertFingerprint string    `json:"cert_fingerprint,omitempty"`
+	Protocols       []string  `json:"protocols,omitempty"`
+}
+
+// Supports reports whether the relay advertises the given protocol.
+func (s *ServerInfo) Supports(protocol string) bool {
+	return slices.Contains(s.Protocols, protocol)
 }
 
 func New(cfg *config.Config, redis *redis.Client, logger *slog.Logger) (*Conductor, error) {
@@ -38,26 +76,181 @@ func New(cfg *config.Config, redis *redis.Client, logger *slog.Logger) (*Conduct
 	logger = logger.With("component", "conductor")
 
 	tc := &Conductor{
-		cfg:    cfg,
-		logger: logger,
-		rdb:    redis,
+		cfg:         cfg,
+		logger:      logger,
+		rdb:         redis,
+		watchers:    make(map[string]map[chan struct{}]struct{}),
+		staleRelays: make(map[string]time.Time),
 	}
 
 	return tc, nil
 }
 
-func (c *Conductor) AssignRelayServer(projectName string) (*models.RelayAssignment, error) {
-	serverInfos, err := c.rdb.HGetAll(context.Background(), c.cfg.RelayRegistryKey).Result()
+// AssignOptions change how AssignRelayServer treats a project that already
+// has a healthy relay.
+type AssignOptions struct {
+	// Force assigns a new relay even though the current one is healthy,
+	// avoiding the current one if any other is available.
+	Force bool
+	// PreferRelay moves the project to this relay if it is healthy. An
+	// unknown or unhealthy preference is ignored.
+	PreferRelay string
+}
+
+const (
+	// maxAssignAttempts bounds retries when another conductor changes the
+	// project's assignment while this one is deciding.
+	maxAssignAttempts = 5
+	// assignmentHistoryLength is how many records are kept per project.
+	assignmentHistoryLength = 50
+)
+
+// AssignRelayServer returns a project's assignment, assigning a relay only
+// when the project has none, its relay has stopped heartbeating, or opts ask
+// for a different one. Calling it again for a connected project returns the
+// same assignment and generation. Every new assignment is recorded in the
+// project's history with the reason it was made.
+func (c *Conductor) AssignRelayServer(projectName string, opts AssignOptions) (*models.RelayAssignment, error) {
+	for range maxAssignAttempts {
+		assignment, err := c.assign(context.Background(), projectName, opts)
+		if errors.Is(err, errAssignmentChanged) {
+			continue
+		}
+		return assignment, err
+	}
+	return nil, fmt.Errorf("%w: assignment kept changing while assigning a relay", ErrRegistryUnavailable)
+}
+
+// errAssignmentChanged means another call assigned the project between
+// reading its assignment and writing a new one.
+var errAssignmentChanged = errors.New("relay assignment changed concurrently")
+
+// replaceAssignmentScript writes a project's new relay and generation, and
+// records it in the history, only if the project's assignment is still the
+// one it was decided from. It touches nothing but that project's fields, so
+// assigning one project never holds up another.
+const replaceAssignmentScript = `
+local relay = redis.call('HGET', KEYS[1], ARGV[1])
+local generation = redis.call('HGET', KEYS[2], ARGV[1])
+if (relay or '') ~= ARGV[2] or (generation or '0') ~= ARGV[3] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[4])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[5])
+redis.call('LPUSH', KEYS[3], ARGV[6])
+redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[7]) - 1)
+return 1
+`
+
+var replaceAssignment = redis.NewScript(replaceAssignmentScript)
+
+// assign decides an assignment from the project's current one and writes it
+// with replaceAssignmentScript, so concurrent calls for a project can't both
+// assign it. It fails with errAssignmentChanged if the project's assignment
+// changed in the meantime.
+func (c *Conductor) assign(ctx context.Context, projectName string, opts AssignOptions) (*models.RelayAssignment, error) {
+	currentID, generation, err := c.loadAssignment(ctx, projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	relays, err := c.healthyRelays(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get server info: %w", err)
+		return nil, err
 	}
 
-	var (
-		selectedServerID   string
-		selectedServerInfo ServerInfo
-		minLoad            = math.MaxInt32
-	)
+	current, currentHealthy := relays[currentID]
+	preferred, preferredHealthy := relays[opts.PreferRelay]
+	if currentHealthy && !opts.Force && (!preferredHealthy || opts.PreferRelay == currentID) {
+		c.logger.Debug("kept existing relay assignment",
+			"project", projectName,
+			"server_id", currentID,
+			"generation", generation)
+		return &models.RelayAssignment{
+			RelayID:    currentID,
+			RelayWSURL: current.RelayWSUrl,
+			Generation: generation,
+		}, nil
+	}
+
+	var reason string
+	switch {
+	case currentID == "":
+		reason = models.AssignmentReasonNew
+	case !currentHealthy:
+		reason = models.AssignmentReasonRelayGone
+	case opts.Force:
+		reason = models.AssignmentReasonForced
+	default:
+		reason = models.AssignmentReasonPreferred
+	}
+
+	selected := preferred
+	if !preferredHealthy {
+		avoid := ""
+		if opts.Force {
+			avoid = currentID
+		}
+		selected = leastLoaded(relays, avoid)
+	}
+	if selected == nil {
+		return nil, ErrNoRelays
+	}
 
+	// The generation is bumped with every assignment so relays and clients
+	// can tell a fresh assignment from a stale one.
+	record := &models.AssignmentRecord{
+		Generation:      generation + 1,
+		RelayID:         selected.ID,
+		PreviousRelayID: currentID,
+		Reason:          reason,
+		AssignedAt:      time.Now(),
+	}
+	rawRecord, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal assignment record: %w", err)
+	}
+
+	replaced, err := replaceAssignment.Run(ctx, c.rdb,
+		[]string{c.cfg.RelayAssignmentKey, c.cfg.RelayGenerationKey, c.historyKey(projectName)},
+		projectName, currentID, generation, selected.ID, record.Generation, rawRecord, assignmentHistoryLength,
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to set relay assignment: %w", ErrRegistryUnavailable, err)
+	}
+	if replaced == 0 {
+		return nil, errAssignmentChanged
+	}
+
+	assignment := &models.RelayAssignment{
+		RelayID:    selected.ID,
+		RelayWSURL: selected.RelayWSUrl,
+		Generation: record.Generation,
+	}
+
+	c.logger.Info("assigned relay server",
+		"project", projectName,
+		"server_id", assignment.RelayID,
+		"previous_server_id", record.PreviousRelayID,
+		"reason", record.Reason,
+		"relay_ws_url", assignment.RelayWSURL,
+		"generation", assignment.Generation,
+		"load", selected.Load)
+
+	c.publishAssignment(projectName, assignment.Generation)
+
+	return assignment, nil
+}
+
+// healthyRelays returns the registered relays that have sent a heartbeat
+// recently, keyed by ID.
+func (c *Conductor) healthyRelays(ctx context.Context) (map[string]*ServerInfo, error) {
+	serverInfos, err := c.rdb.HGetAll(ctx, c.cfg.RelayRegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get server info: %w", ErrRegistryUnavailable, err)
+	}
+
+	relays := make(map[string]*ServerInfo, len(serverInfos))
 	for serverID, info := range serverInfos {
 		var serverInfo ServerInfo
 		err := json.Unmarshal([]byte(info), &serverInfo)
@@ -69,73 +262,162 @@ func (c *Conductor) AssignRelayServer(projectName string) (*models.RelayAssignme
 			continue
 		}
 
-		if time.Since(serverInfo.LastHeartbeat) > 30*time.Second {
+		if time.Since(serverInfo.LastHeartbeat) > staleHeartbeat {
 			c.logger.Debug("skipping stale server",
 				"server_id", serverID,
 				"last_heartbeat", serverInfo.LastHeartbeat)
 			continue
 		}
 
-		if serverInfo.Load < minLoad {
-			minLoad = serverInfo.Load
-			selectedServerID = serverID
-			selectedServerInfo = serverInfo
+		serverInfo.ID = serverID
+		relays[serverID] = &serverInfo
+	}
+
+	return relays, nil
+}
+
+// leastLoaded picks the relay with the lowest load, skipping avoid unless it
+// is the only one.
+func leastLoaded(relays map[string]*ServerInfo, avoid string) *ServerInfo {
+	var selected *ServerInfo
+	for id, relay := range relays {
+		if id == avoid && len(relays) > 1 {
+			continue
+		}
+		if selected == nil || relay.Load < selected.Load || (relay.Load == selected.Load && id < selected.ID) {
+			selected = relay
 		}
 	}
+	return selected
+}
+
+func (c *Conductor) historyKey(projectName string) string {
+	return c.cfg.AssignmentHistoryKey + ":" + projectName
+}
 
-	if selectedServerID == "" {
-		return nil, errors.New("no available relay servers")
+// AssignmentHistory returns a project's most recent assignments, newest
+// first.
+func (c *Conductor) AssignmentHistory(projectName string) ([]*models.AssignmentRecord, error) {
+	raw, err := c.rdb.LRange(context.Background(), c.historyKey(projectName), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to fetch assignment history: %w", ErrRegistryUnavailable, err)
+	}
+
+	records := make([]*models.AssignmentRecord, 0, len(raw))
+	for _, entry := range raw {
+		var record models.AssignmentRecord
+		if err := json.Unmarshal([]byte(entry), &record); err != nil {
+			c.logger.Error("failed to unmarshal assignment record",
+				"project", projectName,
+				"error", err)
+			continue
+		}
+		records = append(records, &record)
 	}
 
-	err = c.rdb.HSet(context.Background(),
-		c.cfg.RelayAssignmentKey,
-		projectName,
-		selectedServerID,
-	).Err()
+	return records, nil
+}
+
+// GetProjectRelay returns the registry entry for the relay currently assigned
+// to a project, with the generation of that assignment.
+func (c *Conductor) GetProjectRelay(projectName string) (*ServerInfo, int64, error) {
+	relayServer, generation, err := c.readAssignment(projectName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to set relay assignment: %w", err)
+		return nil, 0, err
 	}
 
-	assignment := &models.RelayAssignment{
-		RelayID:    selectedServerID,
-		RelayWSURL: selectedServerInfo.RelayWSUrl,
+	serverInfo, err := c.relayInfo(relayServer)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	c.logger.Info("assigned relay server",
-		"project", projectName,
-		"server_id", selectedServerID,
-		"relay_url", selectedServerInfo.RelayUrl,
-		"relay_ws_url", selectedServerInfo.RelayWSUrl,
-		"load", minLoad)
+	return serverInfo, generation, nil
+}
 
-	return assignment, nil
+// CurrentAssignment returns a project's assignment as it stands, without
+// creating one. It fails with ErrProjectNotAssigned if there is none and
+// ErrRelayGone if the assigned relay has stopped sending heartbeats.
+func (c *Conductor) CurrentAssignment(projectName string) (*models.RelayAssignment, error) {
+	relayServer, generation, err := c.readAssignment(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	serverInfo, err := c.relayInfo(relayServer)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(serverInfo.LastHeartbeat) > staleHeartbeat {
+		return nil, ErrRelayGone
+	}
+
+	return &models.RelayAssignment{
+		RelayID:    serverInfo.ID,
+		RelayWSURL: serverInfo.RelayWSUrl,
+		Generation: generation,
+	}, nil
 }
 
-func (c *Conductor) GetProjectRelayServer(projectName string) (string, error) {
-	relayServer, err := c.rdb.HGet(context.Background(),
-		c.cfg.RelayAssignmentKey,
-		projectName).Result()
+// readAssignment returns the relay a project is assigned to and the
+// assignment's generation, failing with ErrProjectNotAssigned if there is
+// no relay.
+func (c *Conductor) readAssignment(projectName string) (string, int64, error) {
+	relayID, generation, err := c.loadAssignment(context.Background(), projectName)
 	if err != nil {
-		return "", fmt.Errorf("unable to find relay server assigned to project: %w", err)
+		return "", 0, err
+	}
+	if relayID == "" {
+		return "", 0, ErrProjectNotAssigned
+	}
+	return relayID, generation, nil
+}
+
+// loadAssignment returns a project's relay, "" if it has none, and the
+// generation of its last assignment, which outlives a removed relay. Both
+// are read in one transaction so the generation always belongs to the relay
+// it is returned with.
+func (c *Conductor) loadAssignment(ctx context.Context, projectName string) (string, int64, error) {
+	var relayID, generation *redis.StringCmd
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		relayID = pipe.HGet(ctx, c.cfg.RelayAssignmentKey, projectName)
+		generation = pipe.HGet(ctx, c.cfg.RelayGenerationKey, projectName)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, fmt.Errorf("%w: unable to fetch relay assignment: %w", ErrRegistryUnavailable, err)
+	}
+
+	gen, err := generation.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, fmt.Errorf("invalid assignment generation: %w", err)
 	}
 
+	return relayID.Val(), gen, nil
+}
+
+func (c *Conductor) relayInfo(relayServer string) (*ServerInfo, error) {
 	var serverInfo ServerInfo
 	info, err := c.rdb.HGet(context.Background(),
 		c.cfg.RelayRegistryKey,
 		relayServer).Result()
 	if err != nil {
-		return "", fmt.Errorf("unable to fetch relay server info: %w", err)
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrRelayGone
+		}
+		return nil, fmt.Errorf("%w: unable to fetch relay server info: %w", ErrRegistryUnavailable, err)
 	}
 
 	if err := json.Unmarshal([]byte(info), &serverInfo); err != nil {
-		return "", fmt.Errorf("failed to unmarshal server info")
+		return nil, fmt.Errorf("failed to unmarshal server info")
 	}
 
 	if serverInfo.RelayUrl == "" {
-		return "", errors.New("server info does not contain a relay url")
+		return nil, errors.New("server info does not contain a relay url")
 	}
 
-	return serverInfo.RelayUrl, nil
+	serverInfo.ID = relayServer
+
+	return &serverInfo, nil
 }
 
 // TODO: Consider moving this to a separate service, as there will be multiple
@@ -151,7 +433,7 @@ func (c *Conductor) StartCleanupRoutine(ctx context.Context) chan struct{} {
 
 	go func() {
 		defer close(done)
-		ticker := time.NewTicker(time.Duration(30 * time.Second))
+		ticker := time.NewTicker(cleanupInterval)
 		defer ticker.Stop()
 
 		for {
@@ -176,24 +458,67 @@ func (c *Conductor) cleanupDeadRelays() error {
 		return fmt.Errorf("unable to fetch relays: %w", err)
 	}
 
+	stale := make(map[string]time.Time)
+	var newlyStale []string
 	for serverID, info := range serverInfos {
 		var serverInfo ServerInfo
 		json.Unmarshal([]byte(info), &serverInfo)
 
-		if time.Since(serverInfo.LastHeartbeat) > 60*time.Second {
+		switch age := time.Since(serverInfo.LastHeartbeat); {
+		case age > deadRelayTimeout:
 			c.logger.Warn("relay unreachable", "relay_id", serverID)
 			c.reassignRelay(serverID)
 			c.rdb.HDel(context.Background(), c.cfg.RelayRegistryKey, serverID)
+		case age > staleHeartbeat:
+			stale[serverID] = serverInfo.LastHeartbeat
+			if !c.staleRelays[serverID].Equal(serverInfo.LastHeartbeat) {
+				newlyStale = append(newlyStale, serverID)
+			}
+		}
+	}
+	c.staleRelays = stale
+
+	if len(newlyStale) > 0 {
+		if err := c.announceStaleRelays(newlyStale); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// TODO: When the CLI loses connection with the relay server it's going to need
-// to query the API to find the new websocket connection URL - so we need to
-// add a method to find the current relay for a given project, we only support
-// creating a new assignment and reassigning dead ones currently
+// announceStaleRelays tells watchers of every project on the given relays
+// that the assignment changed, so they read it again and see the relay is
+// gone while it waits to be reassigned.
+func (c *Conductor) announceStaleRelays(relayIDs []string) error {
+	var assignments, generations *redis.MapStringStringCmd
+	_, err := c.rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		assignments = pipe.HGetAll(context.Background(), c.cfg.RelayAssignmentKey)
+		generations = pipe.HGetAll(context.Background(), c.cfg.RelayGenerationKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to fetch assignments: %w", err)
+	}
+
+	for projectName, relayID := range assignments.Val() {
+		if !slices.Contains(relayIDs, relayID) {
+			continue
+		}
+		generation, _ := strconv.ParseInt(generations.Val()[projectName], 10, 64)
+
+		c.logger.Info("relay stopped heartbeating, notifying watchers",
+			"project", projectName,
+			"relay_id", relayID)
+		c.publishAssignment(projectName, generation)
+	}
+
+	return nil
+}
+
+// reassignRelay moves every project on a dead relay to a live one. The CLI
+// finds its new relay through CurrentAssignment, and the bumped generation
+// tells it the assignment changed.
 func (c *Conductor) reassignRelay(serverID string) error {
 	c.logger.Info("reassigning relay", "relay_id", serverID)
 
@@ -208,19 +533,34 @@ func (c *Conductor) reassignRelay(serverID string) error {
 				"project", projectName,
 				"old_relay", serverID)
 
-			newRelay, err := c.AssignRelayServer(projectName)
+			newRelay, err := c.AssignRelayServer(projectName, AssignOptions{})
 			if err != nil {
 				c.logger.Error("failed to reassign project to new relay",
 					"project", projectName,
 					"error", err)
 
+				// The generation outlives the deleted relay, so watchers are
+				// told the one the project had rather than a bogus 0.
+				relayID, generation, err := c.loadAssignment(context.Background(), projectName)
+				if err != nil {
+					c.logger.Error("failed to load stale assignment",
+						"project", projectName,
+						"error", err)
+					continue
+				}
+				if relayID != serverID {
+					// Another conductor has moved the project already.
+					continue
+				}
 				if err := c.rdb.HDel(context.Background(),
 					c.cfg.RelayAssignmentKey,
 					projectName).Err(); err != nil {
 					c.logger.Error("failed to delete stale assignment",
 						"project", projectName,
 						"error", err)
+					continue
 				}
+				c.publishAssignment(projectName, generation)
 				continue
 			}
 
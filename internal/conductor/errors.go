@@ -0,0 +1,17 @@
+package conductor
+
+import "errors"
+
+var (
+	// ErrNoRelays means no relay has sent a recent heartbeat, so nothing can
+	// be assigned.
+	ErrNoRelays = errors.New("no available relay servers")
+	// ErrProjectNotAssigned means the project has no relay, usually because
+	// its CLI isn't connected.
+	ErrProjectNotAssigned = errors.New("project has no relay assigned")
+	// ErrRelayGone means the assigned relay has dropped out of the registry
+	// and the project is waiting to be reassigned.
+	ErrRelayGone = errors.New("assigned relay is no longer registered")
+	// ErrRegistryUnavailable wraps failures talking to the relay registry.
+	ErrRegistryUnavailable = errors.New("relay registry unavailable")
+)
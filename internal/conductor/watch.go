@@ -0,0 +1,136 @@
+package conductor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// assignmentChange is published on RelayAssignmentChannel whenever a
+// project's assignment is created, moved or removed, so watchers on every
+// conductor instance hear about it.
+type assignmentChange struct {
+	Project    string `json:"project"`
+	Generation int64  `json:"generation"`
+}
+
+// WatchAssignment returns a channel that receives a value whenever the
+// project's assignment may have changed. Changes that arrive while a value
+// is still pending are coalesced, so watchers should re-read the assignment
+// rather than count notifications. stop must be called once the watcher is
+// done.
+func (c *Conductor) WatchAssignment(projectName string) (changes <-chan struct{}, stop func()) {
+	ch := make(chan struct{}, 1)
+
+	c.watchMu.Lock()
+	if c.watchers[projectName] == nil {
+		c.watchers[projectName] = make(map[chan struct{}]struct{})
+	}
+	c.watchers[projectName][ch] = struct{}{}
+	c.watchMu.Unlock()
+
+	return ch, func() {
+		c.watchMu.Lock()
+		delete(c.watchers[projectName], ch)
+		if len(c.watchers[projectName]) == 0 {
+			delete(c.watchers, projectName)
+		}
+		c.watchMu.Unlock()
+	}
+}
+
+// StartAssignmentEvents subscribes to assignment changes from every
+// conductor and passes them to local watchers until ctx is cancelled.
+func (c *Conductor) StartAssignmentEvents(ctx context.Context) chan struct{} {
+	done := make(chan struct{})
+	pubsub := c.rdb.Subscribe(ctx, c.cfg.RelayAssignmentChannel)
+
+	c.logger.Info("watching relay assignment changes", "channel", c.cfg.RelayAssignmentChannel)
+
+	go func() {
+		defer close(done)
+		defer pubsub.Close()
+
+		subscribed := false
+		for {
+			select {
+			case msg, ok := <-pubsub.ChannelWithSubscriptions():
+				if !ok {
+					return
+				}
+				switch msg := msg.(type) {
+				case *redis.Subscription:
+					// Changes published while the subscription was down are
+					// lost, so everyone re-reads after a reconnect.
+					if subscribed && msg.Kind == "subscribe" {
+						c.logger.Warn("resubscribed to assignment changes, notifying all watchers")
+						c.notifyAll()
+					}
+					subscribed = true
+				case *redis.Message:
+					var change assignmentChange
+					if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+						c.logger.Error("failed to unmarshal assignment change",
+							"payload", msg.Payload,
+							"error", err)
+						continue
+					}
+					c.notify(change.Project)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+func (c *Conductor) notify(projectName string) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	for ch := range c.watchers[projectName] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Conductor) notifyAll() {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	for _, watchers := range c.watchers {
+		for ch := range watchers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// publishAssignment tells every conductor that a project's assignment
+// changed. A failure only delays watchers until their next poll, so it is
+// logged rather than returned.
+func (c *Conductor) publishAssignment(projectName string, generation int64) {
+	raw, err := json.Marshal(assignmentChange{Project: projectName, Generation: generation})
+	if err != nil {
+		c.logger.Error("failed to marshal assignment change", "project", projectName, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.rdb.Publish(ctx, c.cfg.RelayAssignmentChannel, raw).Err(); err != nil {
+		c.logger.Warn("failed to publish assignment change",
+			"project", projectName,
+			"generation", generation,
+			"error", err)
+	}
+}
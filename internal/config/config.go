@@ -1,24 +1,180 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// BaseDomainConfig is a domain webhooks arrive under and how a project is
+// found beneath it: "subdomain" for <project>.<name>, or "path" for
+// <name>/p/{project}/... where wildcard DNS or certificates aren't available.
+type BaseDomainConfig struct {
+	Name        string
+	RoutingMode string
+}
+
+// ListenerConfig is the address and timeouts of one of the conductor's HTTP
+// listeners.
+type ListenerConfig struct {
+	Addr         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// defaultAdminNetworks are the only peers the admin listener answers when
+// ADMIN_ALLOWED_NETWORKS isn't set: loopback and private ranges.
+var defaultAdminNetworks = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
 type Config struct {
 	Port int
 	Host string
 
+	// PublicListener receives webhooks and serves the API host.
+	PublicListener ListenerConfig
+	// AdminListener serves the project management API, which must never be
+	// reachable from the internet. AdminAllowedNetworks restricts its peers.
+	AdminListener        ListenerConfig
+	AdminAllowedNetworks []netip.Prefix
+	// MetricsListener serves /metrics for scrapers.
+	MetricsListener ListenerConfig
+
 	PostgresURL string
 	RedisURL    string
 
 	RelayRegistryKey   string
 	RelayAssignmentKey string
+	RelayGenerationKey string
+	// AssignmentHistoryKey prefixes the per-project lists recording why each
+	// assignment was made.
+	AssignmentHistoryKey string
+	// RelayAssignmentChannel is the pub/sub channel conductors announce
+	// assignment changes on, so watchers on any instance are told at once.
+	RelayAssignmentChannel string
+	ProjectSettingsKey     string
+	CustomDomainsKey       string
+	// CustomDomainClaimTTL is how long a project has to verify a domain it
+	// has claimed before another project may claim it.
+	CustomDomainClaimTTL time.Duration
+
+	// BaseDomain is the primary base domain, the first of BaseDomains.
+	BaseDomain  string
+	BaseDomains []BaseDomainConfig
+
+	// TLS on the public listener, which also makes HTTP/2 available to
+	// senders, is enabled by any certificate source or an ACME directory.
+	// Certificates are chosen by SNI and reloaded every TLSReloadInterval.
+	// TLSCertFile and TLSKeyFile are a single certificate, TLSCertDir holds
+	// <name>.crt and <name>.key pairs, and TLSCertsFromPostgres reads the
+	// tls_certificates table.
+	TLSCertFile          string
+	TLSKeyFile           string
+	TLSCertDir           string
+	TLSCertsFromPostgres bool
+	TLSMinVersion        uint16
+	TLSReloadInterval    time.Duration
+	// ACMEDirectoryURL enables issuing certificates on demand for verified
+	// custom domains, using the TLS-ALPN-01 challenge. Base domains need a
+	// wildcard certificate from one of the sources.
+	// ACMECAFile trusts a private directory such as a local Pebble.
+	ACMEDirectoryURL   string
+	ACMEEmail          string
+	ACMEAccountKeyFile string
+	ACMECAFile         string
+	// H2CEnabled accepts HTTP/2 without TLS (h2c) from trusted proxies that
+	// terminate TLS in front of the conductor.
+	H2CEnabled bool
+
+	// TrustedProxies lists the networks whose forwarding headers
+	// (X-Forwarded-For, X-Forwarded-Proto) are believed.
+	TrustedProxies []netip.Prefix
+
+	// APIKeyRotationGrace is how long a rotated API key keeps working, unless
+	// the rotation asks for a different grace. APIKeyMaxRotationGrace caps it.
+	APIKeyRotationGrace    time.Duration
+	APIKeyMaxRotationGrace time.Duration
+
+	RelaySigningKeys  string
+	RelaySigningKeyID string
+
+	// AssignmentSigningKeys are the Ed25519 seeds relay assignment tokens are
+	// signed with, as id:base64-seed pairs. All of them are published for
+	// relays; AssignmentSigningKeyID picks the one that signs.
+	AssignmentSigningKeys  string
+	AssignmentSigningKeyID string
+	AssignmentTokenTTL     time.Duration
+
+	// AssignmentLongPollTimeout is how long a long-polling watch waits for a
+	// change before answering 204. AssignmentStreamTimeout ends event
+	// streams, which clients then reopen.
+	AssignmentLongPollTimeout time.Duration
+	AssignmentStreamTimeout   time.Duration
+
+	RelayTLSCertFile       string
+	RelayTLSKeyFile        string
+	RelayTLSCAFile         string
+	RelayTLSReloadInterval time.Duration
+
+	RelayDialTimeout           time.Duration
+	RelayTLSHandshakeTimeout   time.Duration
+	RelayResponseHeaderTimeout time.Duration
+	RelayIdleConnTimeout       time.Duration
+	RelayMaxIdleConnsPerHost   int
+	RelayFlushInterval         time.Duration
+	// RelayUpgradeIdleTimeout closes upgraded connections, such as
+	// WebSockets, once no bytes have moved in either direction for this long.
+	RelayUpgradeIdleTimeout time.Duration
+	// RelayStreamTimeout bounds how long a forwarded response may keep
+	// streaming. It replaces the server's write timeout for relay traffic.
+	RelayStreamTimeout time.Duration
+	// RelayMaxTimeoutOverride caps the timeouts projects can configure.
+	RelayMaxTimeoutOverride time.Duration
+
+	// RelayLegacyProjectQuery appends project=<name> to forwarded URLs for
+	// relays that predate the X-Whook-Project header.
+	RelayLegacyProjectQuery bool
 
-	BaseDomain string
+	RelayRetryMaxAttempts int
+	RelayRetryBaseDelay   time.Duration
+	RelayRetryMaxDelay    time.Duration
+	// RelayRetryStatuses are the relay response codes that are retried in
+	// addition to connection errors.
+	RelayRetryStatuses []int
+	// RelayRetrySyncBudget bounds how long a sender waits on retries in sync
+	// mode. It should stay below the server's write timeout.
+	RelayRetrySyncBudget time.Duration
+
+	// DeliveryMode is sync to proxy the relay's response back to the sender,
+	// or async to acknowledge once stored and deliver in the background.
+	DeliveryMode           string
+	AsyncDeliveryTTL       time.Duration
+	AsyncDeliveryMaxDelay  time.Duration
+	AsyncDeliveryWorkers   int
+	AsyncDeliveryQueueSize int
+
+	StorageQueueSize      int
+	StorageBatchSize      int
+	StorageFlushInterval  time.Duration
+	StorageEnqueueTimeout time.Duration
+	StorageOverflowPolicy string
+	StorageSpillDir       string
+
+	DedupKeyPrefix     string
+	DedupDefaultWindow time.Duration
 
 	IsDevelopment bool
 }
@@ -31,16 +187,224 @@ func NewConfig() (*Config, error) {
 		return nil, fmt.Errorf("invalid port: %w", err)
 	}
 
-	return &Config{
-		Port:               port,
-		Host:               getEnvWithDefault("HOST", "0.0.0.0"),
-		PostgresURL:        requireEnv("POSTGRES_URL"),
-		RedisURL:           requireEnv("REDIS_URL"),
-		RelayRegistryKey:   getEnvWithDefault("RELAY_REGISTRY_KEY", "relay_servers"),
-		RelayAssignmentKey: getEnvWithDefault("RELAY_ASSIGNMENT_KEY", "relay_assignments"),
-		BaseDomain:         getEnvWithDefault("BASE_DOMAIN", "whook.dev"),
-		IsDevelopment:      getEnvWithDefault("ENVIRONMENT", "development") == "development",
-	}, nil
+	cfg := &Config{
+		Port:                    port,
+		Host:                    getEnvWithDefault("HOST", "0.0.0.0"),
+		PostgresURL:             requireEnv("POSTGRES_URL"),
+		RedisURL:                requireEnv("REDIS_URL"),
+		RelayRegistryKey:        getEnvWithDefault("RELAY_REGISTRY_KEY", "relay_servers"),
+		RelayAssignmentKey:      getEnvWithDefault("RELAY_ASSIGNMENT_KEY", "relay_assignments"),
+		RelayGenerationKey:      getEnvWithDefault("RELAY_GENERATION_KEY", "relay_assignment_generations"),
+		AssignmentHistoryKey:    getEnvWithDefault("ASSIGNMENT_HISTORY_KEY", "relay_assignment_history"),
+		RelayAssignmentChannel:  getEnvWithDefault("RELAY_ASSIGNMENT_CHANNEL", "relay_assignment_changes"),
+		ProjectSettingsKey:      getEnvWithDefault("PROJECT_SETTINGS_KEY", "project_settings"),
+		CustomDomainsKey:        getEnvWithDefault("CUSTOM_DOMAINS_KEY", "custom_domains"),
+		BaseDomain:              getEnvWithDefault("BASE_DOMAIN", "whook.dev"),
+		TLSCertFile:             os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:              os.Getenv("TLS_KEY_FILE"),
+		TLSCertDir:              os.Getenv("TLS_CERT_DIR"),
+		TLSCertsFromPostgres:    getEnvWithDefault("TLS_CERTS_FROM_POSTGRES", "false") == "true",
+		ACMEDirectoryURL:        os.Getenv("ACME_DIRECTORY_URL"),
+		ACMEEmail:               os.Getenv("ACME_EMAIL"),
+		ACMEAccountKeyFile:      os.Getenv("ACME_ACCOUNT_KEY_FILE"),
+		ACMECAFile:              os.Getenv("ACME_CA_FILE"),
+		H2CEnabled:              getEnvWithDefault("H2C_ENABLED", "false") == "true",
+		RelaySigningKeys:        os.Getenv("RELAY_SIGNING_KEYS"),
+		RelaySigningKeyID:       os.Getenv("RELAY_SIGNING_KEY_ID"),
+		AssignmentSigningKeys:   os.Getenv("ASSIGNMENT_SIGNING_KEYS"),
+		AssignmentSigningKeyID:  os.Getenv("ASSIGNMENT_SIGNING_KEY_ID"),
+		RelayTLSCertFile:        os.Getenv("RELAY_TLS_CERT_FILE"),
+		RelayTLSKeyFile:         os.Getenv("RELAY_TLS_KEY_FILE"),
+		RelayTLSCAFile:          os.Getenv("RELAY_TLS_CA_FILE"),
+		RelayLegacyProjectQuery: getEnvWithDefault("RELAY_LEGACY_PROJECT_QUERY", "false") == "true",
+		DeliveryMode:            getEnvWithDefault("DELIVERY_MODE", "sync"),
+		StorageOverflowPolicy:   getEnvWithDefault("STORAGE_OVERFLOW_POLICY", "block"),
+		StorageSpillDir:         os.Getenv("STORAGE_SPILL_DIR"),
+		DedupKeyPrefix:          getEnvWithDefault("DEDUP_KEY_PREFIX", "dedup"),
+		IsDevelopment:           getEnvWithDefault("ENVIRONMENT", "development") == "development",
+	}
+
+	if cfg.TrustedProxies, err = getEnvPrefixes("TRUSTED_PROXIES"); err != nil {
+		return nil, err
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	switch v := getEnvWithDefault("TLS_MIN_VERSION", "1.2"); v {
+	case "1.2":
+		cfg.TLSMinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.TLSMinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION %q: must be 1.2 or 1.3", v)
+	}
+	if cfg.TLSReloadInterval, err = getEnvDuration("TLS_RELOAD_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.H2CEnabled && len(cfg.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("H2C_ENABLED requires TRUSTED_PROXIES")
+	}
+
+	if cfg.CustomDomainClaimTTL, err = getEnvDuration("CUSTOM_DOMAIN_CLAIM_TTL", 72*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.APIKeyRotationGrace, err = getEnvDuration("API_KEY_ROTATION_GRACE", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.APIKeyMaxRotationGrace, err = getEnvDuration("API_KEY_MAX_ROTATION_GRACE", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.AssignmentTokenTTL, err = getEnvDuration("ASSIGNMENT_TOKEN_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+
+	if cfg.AssignmentLongPollTimeout, err = getEnvDuration("ASSIGNMENT_LONG_POLL_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.AssignmentStreamTimeout, err = getEnvDuration("ASSIGNMENT_STREAM_TIMEOUT", time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayTLSReloadInterval, err = getEnvDuration("RELAY_TLS_RELOAD_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayDialTimeout, err = getEnvDuration("RELAY_DIAL_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayTLSHandshakeTimeout, err = getEnvDuration("RELAY_TLS_HANDSHAKE_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayResponseHeaderTimeout, err = getEnvDuration("RELAY_RESPONSE_HEADER_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayIdleConnTimeout, err = getEnvDuration("RELAY_IDLE_CONN_TIMEOUT", 90*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayMaxIdleConnsPerHost, err = getEnvInt("RELAY_MAX_IDLE_CONNS_PER_HOST", 32); err != nil {
+		return nil, err
+	}
+	if cfg.RelayFlushInterval, err = getEnvDuration("RELAY_FLUSH_INTERVAL", 100*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayUpgradeIdleTimeout, err = getEnvDuration("RELAY_UPGRADE_IDLE_TIMEOUT", 5*time.Minute); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayStreamTimeout, err = getEnvDuration("RELAY_STREAM_TIMEOUT", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.RelayMaxTimeoutOverride, err = getEnvDuration("RELAY_MAX_TIMEOUT_OVERRIDE", time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayRetryMaxAttempts, err = getEnvInt("RELAY_RETRY_MAX_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.RelayRetryBaseDelay, err = getEnvDuration("RELAY_RETRY_BASE_DELAY", 200*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.RelayRetryMaxDelay, err = getEnvDuration("RELAY_RETRY_MAX_DELAY", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayRetryStatuses, err = getEnvInts("RELAY_RETRY_STATUSES", []int{502, 503, 504}); err != nil {
+		return nil, err
+	}
+	if cfg.RelayRetrySyncBudget, err = getEnvDuration("RELAY_RETRY_SYNC_BUDGET", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RelayRetryMaxAttempts < 1 {
+		return nil, fmt.Errorf("RELAY_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+
+	switch cfg.DeliveryMode {
+	case "sync", "async":
+	default:
+		return nil, fmt.Errorf("invalid delivery mode: %q", cfg.DeliveryMode)
+	}
+
+	// BASE_DOMAINS lists name=mode pairs; without it BASE_DOMAIN and
+	// ROUTING_MODE describe a single domain.
+	if cfg.BaseDomains, err = getEnvBaseDomains("BASE_DOMAINS"); err != nil {
+		return nil, err
+	}
+	if len(cfg.BaseDomains) == 0 {
+		cfg.BaseDomains = []BaseDomainConfig{{
+			Name:        cfg.BaseDomain,
+			RoutingMode: getEnvWithDefault("ROUTING_MODE", "subdomain"),
+		}}
+	}
+	for _, domain := range cfg.BaseDomains {
+		switch domain.RoutingMode {
+		case "subdomain", "path":
+		default:
+			return nil, fmt.Errorf("invalid routing mode for %s: %q", domain.Name, domain.RoutingMode)
+		}
+	}
+	cfg.BaseDomain = cfg.BaseDomains[0].Name
+
+	if cfg.PublicListener, err = getEnvListener("PUBLIC", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		15*time.Second, 15*time.Second, 60*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.AdminListener, err = getEnvListener("ADMIN", "127.0.0.1:3001",
+		30*time.Second, 30*time.Second, 60*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MetricsListener, err = getEnvListener("METRICS", "127.0.0.1:9090",
+		5*time.Second, 10*time.Second, 60*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.AdminAllowedNetworks, err = getEnvPrefixes("ADMIN_ALLOWED_NETWORKS"); err != nil {
+		return nil, err
+	}
+	if len(cfg.AdminAllowedNetworks) == 0 {
+		cfg.AdminAllowedNetworks = defaultAdminNetworks
+	}
+
+	if cfg.AsyncDeliveryTTL, err = getEnvDuration("ASYNC_DELIVERY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.AsyncDeliveryMaxDelay, err = getEnvDuration("ASYNC_DELIVERY_MAX_DELAY", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.AsyncDeliveryWorkers, err = getEnvInt("ASYNC_DELIVERY_WORKERS", 8); err != nil {
+		return nil, err
+	}
+	if cfg.AsyncDeliveryQueueSize, err = getEnvInt("ASYNC_DELIVERY_QUEUE_SIZE", 1024); err != nil {
+		return nil, err
+	}
+
+	if cfg.StorageQueueSize, err = getEnvInt("STORAGE_QUEUE_SIZE", 1024); err != nil {
+		return nil, err
+	}
+	if cfg.StorageBatchSize, err = getEnvInt("STORAGE_BATCH_SIZE", 100); err != nil {
+		return nil, err
+	}
+	if cfg.StorageFlushInterval, err = getEnvDuration("STORAGE_FLUSH_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.StorageEnqueueTimeout, err = getEnvDuration("STORAGE_ENQUEUE_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+
+	switch cfg.StorageOverflowPolicy {
+	case "block", "drop_oldest":
+	case "spill":
+		if cfg.StorageSpillDir == "" {
+			return nil, fmt.Errorf("STORAGE_SPILL_DIR is required when STORAGE_OVERFLOW_POLICY is spill")
+		}
+	default:
+		return nil, fmt.Errorf("invalid storage overflow policy: %q", cfg.StorageOverflowPolicy)
+	}
+
+	if cfg.DedupDefaultWindow, err = getEnvDuration("DEDUP_DEFAULT_WINDOW", 24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
 }
 
 func requireEnv(key string) string {
@@ -59,3 +423,132 @@ func getEnvWithDefault(key, defaultValue string) string {
 
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	// Every duration is an interval or timeout, and zero would mean a ticker
+	// that panics or a deadline that has already passed.
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be positive", key)
+	}
+
+	return d, nil
+}
+
+// getEnvInts parses a comma-separated list of integers.
+func getEnvInts(key string, defaultValue []int) ([]int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	var ints []int
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", key, entry, err)
+		}
+		ints = append(ints, n)
+	}
+
+	return ints, nil
+}
+
+// getEnvPrefixes parses a comma-separated list of CIDRs or bare addresses.
+func getEnvPrefixes(key string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %w", key, entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", key, entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// getEnvBaseDomains parses a comma-separated list of domain=mode entries. A
+// domain without a mode uses subdomain routing.
+func getEnvBaseDomains(key string) ([]BaseDomainConfig, error) {
+	var domains []BaseDomainConfig
+
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, mode, found := strings.Cut(entry, "=")
+		if !found {
+			mode = "subdomain"
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: missing domain", key, entry)
+		}
+		domains = append(domains, BaseDomainConfig{Name: name, RoutingMode: strings.TrimSpace(mode)})
+	}
+
+	return domains, nil
+}
+
+// getEnvListener reads <prefix>_ADDR and the listener's timeouts from
+// <prefix>_READ_TIMEOUT, <prefix>_WRITE_TIMEOUT and <prefix>_IDLE_TIMEOUT.
+func getEnvListener(prefix, addr string, read, write, idle time.Duration) (ListenerConfig, error) {
+	l := ListenerConfig{Addr: getEnvWithDefault(prefix+"_ADDR", addr)}
+
+	var err error
+	if l.ReadTimeout, err = getEnvDuration(prefix+"_READ_TIMEOUT", read); err != nil {
+		return l, err
+	}
+	if l.WriteTimeout, err = getEnvDuration(prefix+"_WRITE_TIMEOUT", write); err != nil {
+		return l, err
+	}
+	if l.IdleTimeout, err = getEnvDuration(prefix+"_IDLE_TIMEOUT", idle); err != nil {
+		return l, err
+	}
+
+	return l, nil
+}
@@ -0,0 +1,195 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// MaxWindow caps how long a project may ask for idempotency keys to be
+// remembered.
+const MaxWindow = 30 * 24 * time.Hour
+
+type Deduplicator struct {
+	cfg    *config.Config
+	rdb    *redis.Client
+	logger *slog.Logger
+}
+
+func New(cfg *config.Config, rdb *redis.Client, logger *slog.Logger) *Deduplicator {
+	return &Deduplicator{
+		cfg:    cfg,
+		rdb:    rdb,
+		logger: logger.With("component", "dedup"),
+	}
+}
+
+// ExtractKey pulls the idempotency key named by the rule out of a request. It
+// reports false when the request doesn't carry one.
+func ExtractKey(rule *models.DedupRule, header http.Header, body []byte) (string, bool) {
+	if rule.Header != "" {
+		key := strings.TrimSpace(header.Get(rule.Header))
+		return key, key != ""
+	}
+
+	if rule.JSONPath != "" {
+		return lookupJSONPath(body, rule.JSONPath)
+	}
+
+	return "", false
+}
+
+// Claim records requestID as the first delivery for key within the window. If
+// another request already claimed the key, its ID is returned along with
+// duplicate set to true.
+func (d *Deduplicator) Claim(ctx context.Context, projectName, key, requestID string, window time.Duration) (originalID string, duplicate bool, err error) {
+	claimKey := d.redisKey("claim", projectName, key)
+
+	ok, err := d.rdb.SetNX(ctx, claimKey, requestID, window).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if ok {
+		return requestID, false, nil
+	}
+
+	originalID, err = d.rdb.Get(ctx, claimKey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			// The claim expired between SETNX and GET, so treat this as a
+			// fresh delivery rather than guessing at an original.
+			return requestID, false, nil
+		}
+		return "", false, fmt.Errorf("failed to read idempotency claim: %w", err)
+	}
+
+	return originalID, true, nil
+}
+
+func (d *Deduplicator) SaveResponse(ctx context.Context, projectName, key string, resp *models.CapturedResponse, window time.Duration) error {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	if err := d.rdb.Set(ctx, d.redisKey("response", projectName, key), raw, window).Err(); err != nil {
+		return fmt.Errorf("failed to save response: %w", err)
+	}
+
+	return nil
+}
+
+// Response returns the response saved for the original delivery of key, or
+// nil if there isn't one yet.
+func (d *Deduplicator) Response(ctx context.Context, projectName, key string) (*models.CapturedResponse, error) {
+	raw, err := d.rdb.Get(ctx, d.redisKey("response", projectName, key)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch response: %w", err)
+	}
+
+	var resp models.CapturedResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// redisKey hashes the idempotency key so arbitrarily long or oddly encoded
+// provider IDs map to a fixed-size Redis key.
+func (d *Deduplicator) redisKey(kind, projectName, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s:%s:%s:%s", d.cfg.DedupKeyPrefix, kind, projectName, hex.EncodeToString(sum[:]))
+}
+
+// lookupJSONPath resolves a dotted path such as "$.data.object.id" or
+// "events[0].id" against a JSON body. Only scalar values are returned.
+func lookupJSONPath(body []byte, path string) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return "", false
+	}
+
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		name, indexes, ok := parseSegment(segment)
+		if !ok {
+			return "", false
+		}
+
+		if name != "" {
+			obj, ok := current.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			if current, ok = obj[name]; !ok {
+				return "", false
+			}
+		}
+
+		for _, idx := range indexes {
+			arr, ok := current.([]any)
+			if !ok || idx < 0 || idx >= len(arr) {
+				return "", false
+			}
+			current = arr[idx]
+		}
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, v != ""
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+func parseSegment(segment string) (string, []int, bool) {
+	name, rest, found := strings.Cut(segment, "[")
+	if !found {
+		return name, nil, name != ""
+	}
+
+	var indexes []int
+	for _, part := range strings.Split("["+rest, "[")[1:] {
+		raw, ok := strings.CutSuffix(part, "]")
+		if !ok {
+			return "", nil, false
+		}
+		idx, err := strconv.Atoi(raw)
+		if err != nil {
+			return "", nil, false
+		}
+		indexes = append(indexes, idx)
+	}
+
+	return name, indexes, true
+}
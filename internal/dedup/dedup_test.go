@@ -0,0 +1,141 @@
+package dedup
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	const body = `{
+		"id": "evt_1",
+		"data": {"object": {"id": "pi_123", "amount": 4200, "big": 12345678901234567890, "live": false, "empty": "", "none": null}},
+		"events": [{"id": "a"}, {"id": "b", "tags": [["x", "y"], ["z"]]}],
+		"dotted.key": "unreachable"
+	}`
+
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{"id", "evt_1", true},
+		{"$.id", "evt_1", true},
+		{".id", "evt_1", true},
+		{"$.data.object.id", "pi_123", true},
+		{"data.object.amount", "4200", true},
+		// Numbers keep their exact digits rather than passing through float64.
+		{"data.object.big", "12345678901234567890", true},
+		{"data.object.live", "false", true},
+		{"events[0].id", "a", true},
+		{"events[1].id", "b", true},
+		{"events[1].tags[0][1]", "y", true},
+		{"events[1].tags[1][0]", "z", true},
+
+		{"", "", false},
+		{"$", "", false},
+		{"missing", "", false},
+		{"data.missing.id", "", false},
+		{"data.object.empty", "", false},
+		{"data.object.none", "", false},
+		{"data.object", "", false},
+		{"events", "", false},
+		{"events[2].id", "", false},
+		{"events[-1].id", "", false},
+		{"events[x].id", "", false},
+		{"events[0", "", false},
+		{"events[", "", false},
+		{"events[0]x", "", false},
+		{"events[]", "", false},
+		{"id[0]", "", false},
+		{"data..id", "", false},
+		{"events.0.id", "", false},
+		{"dotted.key", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := lookupJSONPath([]byte(body), tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("lookupJSONPath(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLookupJSONPathBodies(t *testing.T) {
+	tests := []struct {
+		body   string
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{`[{"id": "first"}]`, "[0].id", "first", true},
+		{`{"id": "x"} trailing`, "id", "x", true},
+		{`not json`, "id", "", false},
+		{``, "id", "", false},
+		{`"just a string"`, "id", "", false},
+		{`{"id": {"nested": true}}`, "id", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := lookupJSONPath([]byte(tt.body), tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("lookupJSONPath(%q, %q) = %q, %v; want %q, %v", tt.body, tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	tests := []struct {
+		segment     string
+		wantName    string
+		wantIndexes []int
+		wantOK      bool
+	}{
+		{"id", "id", nil, true},
+		{"events[0]", "events", []int{0}, true},
+		{"tags[1][2]", "tags", []int{1, 2}, true},
+		{"[3]", "", []int{3}, true},
+		{"", "", nil, false},
+		{"events[", "", nil, false},
+		{"events[0", "", nil, false},
+		{"events[a]", "", nil, false},
+		{"events[0]x", "", nil, false},
+		{"events[0][", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		name, indexes, ok := parseSegment(tt.segment)
+		if ok != tt.wantOK || (ok && (name != tt.wantName || !slices.Equal(indexes, tt.wantIndexes))) {
+			t.Errorf("parseSegment(%q) = %q, %v, %v; want %q, %v, %v",
+				tt.segment, name, indexes, ok, tt.wantName, tt.wantIndexes, tt.wantOK)
+		}
+	}
+}
+
+func TestExtractKey(t *testing.T) {
+	header := http.Header{"Idempotency-Key": {"  key-1  "}}
+	body := []byte(`{"id": "evt_1"}`)
+
+	tests := []struct {
+		name   string
+		rule   *models.DedupRule
+		want   string
+		wantOK bool
+	}{
+		{"header trimmed", &models.DedupRule{Header: "Idempotency-Key"}, "key-1", true},
+		{"header case-insensitive", &models.DedupRule{Header: "idempotency-key"}, "key-1", true},
+		{"header missing", &models.DedupRule{Header: "X-Other"}, "", false},
+		{"json path", &models.DedupRule{JSONPath: "$.id"}, "evt_1", true},
+		{"json path missing", &models.DedupRule{JSONPath: "$.nope"}, "", false},
+		{"no rule", &models.DedupRule{}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ExtractKey(tt.rule, header, body)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: ExtractKey = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
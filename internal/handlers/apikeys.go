@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+)
+
+const maxAPIKeyNameLength = 100
+
+type APIKeysHandler struct {
+	cfg    *config.Config
+	keys   *projects.KeyStore
+	logger *slog.Logger
+}
+
+func NewAPIKeysHandler(cfg *config.Config, ks *projects.KeyStore, logger *slog.Logger) *APIKeysHandler {
+	return &APIKeysHandler{
+		cfg:    cfg,
+		keys:   ks,
+		logger: logger.With("component", "api_keys_handler"),
+	}
+}
+
+// createdAPIKey is the only response that ever carries the full key.
+type createdAPIKey struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+func (h *APIKeysHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+
+	keys, err := h.keys.List(r.Context(), projectName)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to list API keys",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, keys)
+}
+
+func (h *APIKeysHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		http.Error(w, "name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	key, secret, err := h.keys.Create(r.Context(), projectName, req.Name)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to create API key",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusCreated, createdAPIKey{APIKey: key, Key: secret})
+}
+
+// HandleRotateAPIKey issues a replacement key. The body may set
+// grace_seconds to override how long the old key keeps working.
+func (h *APIKeysHandler) HandleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	var req struct {
+		GraceSeconds *int `json:"grace_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.ErrorContext(r.Context(), "failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	grace := h.cfg.APIKeyRotationGrace
+	if req.GraceSeconds != nil {
+		// Checked in seconds, since a large value overflows the Duration.
+		if *req.GraceSeconds < 0 || int64(*req.GraceSeconds) > int64(h.cfg.APIKeyMaxRotationGrace/time.Second) {
+			http.Error(w, "grace_seconds is out of range", http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+
+	key, secret, err := h.keys.Rotate(r.Context(), projectName, id, grace)
+	if err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusCreated, createdAPIKey{APIKey: key, Key: secret})
+}
+
+func (h *APIKeysHandler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	if err := h.keys.Revoke(r.Context(), projectName, id); err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIKeysHandler) writeLookupError(w http.ResponseWriter, r *http.Request, projectName, id string, err error) {
+	if errors.Is(err, projects.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.ErrorContext(r.Context(), "unable to update API key",
+		"project", projectName,
+		"key_id", id,
+		"error", err,
+	)
+	http.Error(w, "Unable to update API key", http.StatusInternalServerError)
+}
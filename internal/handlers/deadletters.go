@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/storage"
+)
+
+type DeadLettersHandler struct {
+	cfg        *config.Config
+	storage    *storage.RequestStorage
+	dispatcher *Dispatcher
+	logger     *slog.Logger
+}
+
+func NewDeadLettersHandler(cfg *config.Config, s *storage.RequestStorage, dp *Dispatcher, logger *slog.Logger) *DeadLettersHandler {
+	return &DeadLettersHandler{
+		cfg:        cfg,
+		storage:    s,
+		dispatcher: dp,
+		logger:     logger.With("component", "dead_letters_handler"),
+	}
+}
+
+func (h *DeadLettersHandler) HandleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.DeadLetterFilter{
+		ProjectName: r.PathValue("project"),
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if raw := query.Get("before"); raw != "" {
+		before, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			http.Error(w, "before must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+
+	dls, err := h.storage.ListDeadLetters(r.Context(), filter)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to list dead letters",
+			"project", filter.ProjectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to list dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, dls)
+}
+
+func (h *DeadLettersHandler) HandleGetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	dl, err := h.storage.GetDeadLetter(r.Context(), projectName, id)
+	if err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, dl)
+}
+
+// HandleRedrive takes a dead letter off the queue and schedules it for
+// delivery. If it fails again it returns to the queue.
+func (h *DeadLettersHandler) HandleRedrive(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	storedReq, err := h.storage.GetRequest(r.Context(), projectName, id)
+	if err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	dl, err := h.storage.TakeDeadLetter(r.Context(), projectName, id)
+	if err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	if err := h.dispatcher.Redrive(dl, storedReq); err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to redrive dead letter",
+			"project", projectName,
+			"request_id", id,
+			"error", err,
+		)
+		if err := h.storage.DeadLetter(r.Context(), dl); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to restore dead letter", "request_id", id, "error", err)
+		}
+		http.Error(w, "Unable to redrive dead letter", http.StatusServiceUnavailable)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "redriving dead letter", "project", projectName, "request_id", id)
+
+	writeJSON(w, h.logger, http.StatusAccepted, map[string]string{"request_id": id})
+}
+
+// HandleDiscard drops a dead letter. The request stays in the request log.
+func (h *DeadLettersHandler) HandleDiscard(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	if _, err := h.storage.TakeDeadLetter(r.Context(), projectName, id); err != nil {
+		h.writeLookupError(w, r, projectName, id, err)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "discarded dead letter", "project", projectName, "request_id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DeadLettersHandler) writeLookupError(w http.ResponseWriter, r *http.Request, projectName, id string, err error) {
+	if errors.Is(err, storage.ErrDeadLetterNotFound) || errors.Is(err, storage.ErrRequestNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.ErrorContext(r.Context(), "unable to fetch dead letter",
+		"project", projectName,
+		"request_id", id,
+		"error", err,
+	)
+	http.Error(w, "Unable to fetch dead letter", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, logger *slog.Logger, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("failed to encode response", "error", err)
+	}
+}
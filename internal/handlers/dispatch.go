@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/metrics"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/requestid"
+)
+
+var ErrDispatchQueueFull = errors.New("delivery queue is full")
+
+var pendingDeliveries = metrics.NewGauge("whook_delivery_pending",
+	"Webhooks waiting for background delivery to a relay.")
+
+type dispatchJob struct {
+	in         *http.Request
+	req        *models.StoredRequest
+	attempt    int
+	expiry     time.Time
+	lastStatus int
+	lastErr    error
+}
+
+// Dispatcher delivers webhooks in the background, for async mode and for
+// redriven dead letters. Each attempt looks the relay up again, so a
+// reassignment is picked up between retries, and retries continue until the
+// delivery's TTL runs out. Deliveries still pending at shutdown are moved to
+// the dead-letter queue.
+type Dispatcher struct {
+	cfg       *config.Config
+	conductor *conductor.Conductor
+	forwarder *Forwarder
+	settings  *projects.SettingsStore
+	logger    *slog.Logger
+
+	queue  chan *dispatchJob
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]*dispatchJob
+}
+
+func NewDispatcher(cfg *config.Config, c *conductor.Conductor, f *Forwarder, ss *projects.SettingsStore, logger *slog.Logger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Dispatcher{
+		cfg:       cfg,
+		conductor: c,
+		forwarder: f,
+		settings:  ss,
+		logger:    logger.With("component", "dispatcher"),
+		queue:     make(chan *dispatchJob, cfg.AsyncDeliveryQueueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+		pending:   make(map[string]*dispatchJob),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	d.logger.Info("starting dispatcher",
+		"workers", d.cfg.AsyncDeliveryWorkers,
+		"ttl", d.cfg.AsyncDeliveryTTL)
+
+	for range d.cfg.AsyncDeliveryWorkers {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Enqueue schedules a stored request for delivery. r is only used for the
+// sender's URI and headers; the body comes from the stored request.
+func (d *Dispatcher) Enqueue(r *http.Request, storedReq *models.StoredRequest) error {
+	in := r.Clone(context.Background())
+	in.Body = http.NoBody
+
+	return d.enqueue(&dispatchJob{
+		in:      in,
+		req:     storedReq,
+		attempt: 1,
+		expiry:  storedReq.ReceivedAt.Add(d.cfg.AsyncDeliveryTTL),
+	})
+}
+
+// Redrive schedules a dead letter for delivery again, with a fresh TTL.
+// Attempt numbers carry on from the ones already recorded.
+func (d *Dispatcher) Redrive(dl *models.DeadLetter, storedReq *models.StoredRequest) error {
+	in, err := rebuildRequest(storedReq)
+	if err != nil {
+		return err
+	}
+
+	return d.enqueue(&dispatchJob{
+		in:      in,
+		req:     storedReq,
+		attempt: dl.Attempts + 1,
+		expiry:  time.Now().Add(d.cfg.AsyncDeliveryTTL),
+	})
+}
+
+func (d *Dispatcher) enqueue(job *dispatchJob) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	select {
+	case d.queue <- job:
+		d.pending[job.req.ID] = job
+		pendingDeliveries.Set(float64(len(d.pending)))
+		return nil
+	default:
+		return ErrDispatchQueueFull
+	}
+}
+
+func (d *Dispatcher) finish(job *dispatchJob) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, job.req.ID)
+	pendingDeliveries.Set(float64(len(d.pending)))
+}
+
+// Close stops the workers and dead-letters deliveries that haven't finished,
+// so they can be redriven once the conductor is back.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = make(map[string]*dispatchJob)
+	pendingDeliveries.Set(0)
+	d.mu.Unlock()
+
+	for _, job := range pending {
+		d.forwarder.deadLetter(context.Background(), Delivery{Request: job.req},
+			models.DeadLetterInterrupted, job.attempt-1, job.lastStatus, job.lastErr)
+	}
+	if len(pending) > 0 {
+		d.logger.Warn("dispatcher stopped with undelivered webhooks", "pending", len(pending))
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case job := <-d.queue:
+			d.attempt(job)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(job *dispatchJob) {
+	projectName := job.req.ProjectName
+	delivery := Delivery{Request: job.req}
+	ctx := requestid.NewContext(d.ctx, job.req.ID)
+
+	statusCode := 0
+	relay, generation, err := d.conductor.GetProjectRelay(projectName)
+	if err != nil {
+		d.forwarder.recordAttempt(ctx, delivery, models.DeliveryModeAsync, job.attempt, time.Now(), 0, err)
+	} else {
+		delivery.Relay = relay
+		delivery.Generation = generation
+		if settings, err := d.settings.Get(ctx, projectName); err != nil {
+			d.logger.ErrorContext(ctx, "failed to load project settings", "project", projectName, "error", err)
+		} else {
+			delivery.ApplyTimeouts(settings.Timeouts)
+		}
+		statusCode, err = d.forwarder.Deliver(ctx, job.in, delivery, job.attempt)
+	}
+
+	if d.ctx.Err() != nil {
+		// Shutting down; Close dead-letters whatever is left.
+		return
+	}
+
+	policy := d.forwarder.Policy()
+	if !policy.Retryable(ctx, statusCode, err) {
+		d.finish(job)
+		return
+	}
+	job.lastStatus, job.lastErr = statusCode, err
+
+	delay := policy.Backoff(job.attempt+1, d.cfg.AsyncDeliveryMaxDelay)
+	if time.Now().Add(delay).After(job.expiry) {
+		d.finish(job)
+		d.forwarder.deadLetter(ctx, delivery, models.DeadLetterExpired, job.attempt, statusCode, err)
+		return
+	}
+
+	job.attempt++
+	time.AfterFunc(delay, func() {
+		select {
+		case d.queue <- job:
+		case <-d.ctx.Done():
+		}
+	})
+}
+
+// rebuildRequest recreates the sender's request from what was stored, for
+// deliveries that no longer have the original to hand. Requests stored
+// before the raw URI and every header value were kept are rebuilt from the
+// decoded path and the first value of each header.
+func rebuildRequest(storedReq *models.StoredRequest) (*http.Request, error) {
+	uri := storedReq.RequestURI
+	if uri == "" {
+		uri = storedReq.Path
+		if storedReq.Query != "" {
+			uri += "?" + storedReq.Query
+		}
+	}
+
+	in, err := http.NewRequest(storedReq.Method, uri, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild request: %w", err)
+	}
+	in.RequestURI = uri
+	// The relay sees these as X-Forwarded-Host and X-Forwarded-For.
+	in.Host = storedReq.Host
+	in.RemoteAddr = storedReq.RemoteAddr
+
+	if storedReq.HeaderValues != nil {
+		in.Header = storedReq.HeaderValues.Clone()
+		return in, nil
+	}
+	for name, value := range storedReq.Headers {
+		in.Header.Set(name, value)
+	}
+
+	return in, nil
+}
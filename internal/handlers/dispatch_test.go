@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/relaytls"
+)
+
+func TestRebuildRequest(t *testing.T) {
+	type seen struct {
+		uri    string
+		header http.Header
+	}
+	got := make(chan seen, 1)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- seen{r.RequestURI, r.Header.Clone()}
+	}))
+	defer relay.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		RelayDialTimeout:           5 * time.Second,
+		RelayResponseHeaderTimeout: 5 * time.Second,
+		RelayIdleConnTimeout:       time.Minute,
+		RelayTLSReloadInterval:     time.Minute,
+	}
+	relayTLS, err := relaytls.New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewForwarder(cfg, nil, relayTLS, nil, logger)
+
+	tests := []struct {
+		name   string
+		stored models.StoredRequest
+		uri    string
+		want   http.Header
+	}{
+		{
+			name: "as it arrived",
+			stored: models.StoredRequest{
+				Path:       "/hook/a/b",
+				Query:      "x=~",
+				Headers:    map[string]string{"Accept": "text/plain"},
+				RequestURI: "/hook/a%2Fb?x=%7e&y=a+b",
+				Host:       "acme.hooks.example",
+				RemoteAddr: "203.0.113.7:4000",
+				HeaderValues: http.Header{
+					"Accept":      {"text/plain", "application/json"},
+					"X-Signature": {"t=1,v1=abc"},
+				},
+			},
+			uri: "/hook/a%2Fb?x=%7e&y=a+b",
+			want: http.Header{
+				"Accept":           {"text/plain", "application/json"},
+				"X-Signature":      {"t=1,v1=abc"},
+				"X-Forwarded-Host": {"acme.hooks.example"},
+				"X-Forwarded-For":  {"203.0.113.7"},
+			},
+		},
+		{
+			name: "stored before the raw request was kept",
+			stored: models.StoredRequest{
+				Path:    "/hook",
+				Query:   "x=1",
+				Headers: map[string]string{"Accept": "text/plain"},
+			},
+			uri:  "/hook?x=1",
+			want: http.Header{"Accept": {"text/plain"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := tt.stored
+			stored.ID, stored.Method, stored.ProjectName = "req-1", http.MethodPost, "acme"
+
+			in, err := rebuildRequest(&stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := Delivery{Relay: &conductor.ServerInfo{ID: "relay-1", RelayUrl: relay.URL}, Request: &stored}
+			if _, err := f.Deliver(context.Background(), in, d, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			s := <-got
+			if s.uri != tt.uri {
+				t.Errorf("relay saw request URI %q, want %q", s.uri, tt.uri)
+			}
+			for name, values := range tt.want {
+				if g := strings.Join(s.header.Values(name), " | "); g != strings.Join(values, " | ") {
+					t.Errorf("relay saw %s %q, want %q", name, g, strings.Join(values, " | "))
+				}
+			}
+		})
+	}
+}
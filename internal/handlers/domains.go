@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/routing"
+)
+
+type DomainsHandler struct {
+	cfg      *config.Config
+	domains  *projects.DomainStore
+	resolver *routing.Resolver
+	logger   *slog.Logger
+}
+
+func NewDomainsHandler(cfg *config.Config, ds *projects.DomainStore, resolver *routing.Resolver, logger *slog.Logger) *DomainsHandler {
+	return &DomainsHandler{
+		cfg:      cfg,
+		domains:  ds,
+		resolver: resolver,
+		logger:   logger.With("component", "domains_handler"),
+	}
+}
+
+// domainResponse adds the instructions for proving ownership to a domain
+// that hasn't been verified yet.
+type domainResponse struct {
+	*models.CustomDomain
+	Challenges *domainChallenges `json:"challenges,omitempty"`
+}
+
+type domainChallenges struct {
+	DNS dnsChallenge `json:"dns"`
+}
+
+type dnsChallenge struct {
+	Record string `json:"record"`
+	Value  string `json:"value"`
+}
+
+func newDomainResponse(domain *models.CustomDomain) domainResponse {
+	resp := domainResponse{CustomDomain: domain}
+	if !domain.Verified {
+		resp.Challenges = &domainChallenges{
+			DNS: dnsChallenge{
+				Record: projects.ChallengeRecordPrefix + domain.Hostname,
+				Value:  projects.ChallengeTXTValue(domain.Token),
+			},
+		}
+	}
+	return resp
+}
+
+func (h *DomainsHandler) HandleListDomains(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	if err := routing.ValidateProjectName(projectName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	domains, err := h.domains.List(r.Context(), projectName)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to list custom domains",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to list custom domains", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]domainResponse, 0, len(domains))
+	for _, domain := range domains {
+		resp = append(resp, newDomainResponse(domain))
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, resp)
+}
+
+// HandleRegisterDomain starts a claim on a hostname and answers with the
+// challenges that will prove it.
+func (h *DomainsHandler) HandleRegisterDomain(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	if err := routing.ValidateProjectName(projectName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Hostname string `json:"hostname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	hostname, err := routing.NormalizeHost(req.Hostname)
+	if err != nil || !strings.Contains(hostname, ".") || strings.Contains(req.Hostname, ":") {
+		http.Error(w, "hostname must be a fully qualified domain name without a port", http.StatusBadRequest)
+		return
+	}
+	if h.resolver.UnderBaseDomain(hostname) {
+		http.Error(w, "hostname must not be under one of the conductor's base domains", http.StatusBadRequest)
+		return
+	}
+
+	domain, err := h.domains.Register(r.Context(), projectName, hostname)
+	if err != nil {
+		if errors.Is(err, projects.ErrDomainTaken) {
+			http.Error(w, "Domain is already claimed by another project", http.StatusConflict)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "unable to register custom domain",
+			"project", projectName,
+			"hostname", hostname,
+			"error", err,
+		)
+		http.Error(w, "Unable to register custom domain", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusCreated, newDomainResponse(domain))
+}
+
+func (h *DomainsHandler) HandleGetDomain(w http.ResponseWriter, r *http.Request) {
+	projectName, hostname, ok := domainFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	domain, err := h.domains.Get(r.Context(), projectName, hostname)
+	if err != nil {
+		h.writeLookupError(w, r, projectName, hostname, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, newDomainResponse(domain))
+}
+
+// HandleVerifyDomain runs the challenge named by the method query parameter.
+// Only "dns", the default, is offered.
+func (h *DomainsHandler) HandleVerifyDomain(w http.ResponseWriter, r *http.Request) {
+	projectName, hostname, ok := domainFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	method := r.URL.Query().Get("method")
+	if method == "" {
+		method = models.DomainChallengeDNS
+	}
+	if method != models.DomainChallengeDNS {
+		http.Error(w, "method must be dns", http.StatusBadRequest)
+		return
+	}
+
+	domain, err := h.domains.Verify(r.Context(), projectName, hostname, method)
+	if err != nil {
+		if errors.Is(err, projects.ErrChallengeFailed) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, projects.ErrClaimExpired) {
+			http.Error(w, "Claim expired, register the domain again", http.StatusGone)
+			return
+		}
+		h.writeLookupError(w, r, projectName, hostname, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, newDomainResponse(domain))
+}
+
+func (h *DomainsHandler) HandleDeleteDomain(w http.ResponseWriter, r *http.Request) {
+	projectName, hostname, ok := domainFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.domains.Delete(r.Context(), projectName, hostname); err != nil {
+		h.writeLookupError(w, r, projectName, hostname, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// domainFromPath reads the project and hostname from the path, normalising
+// the hostname the way HandleRegisterDomain stored it. It answers 400 and
+// returns false if either is invalid.
+func domainFromPath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	projectName := r.PathValue("project")
+	if err := routing.ValidateProjectName(projectName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+
+	hostname, err := routing.NormalizeHost(r.PathValue("hostname"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return projectName, hostname, true
+}
+
+func (h *DomainsHandler) writeLookupError(w http.ResponseWriter, r *http.Request, projectName, hostname string, err error) {
+	if errors.Is(err, projects.ErrDomainNotFound) {
+		http.Error(w, "Custom domain not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.ErrorContext(r.Context(), "unable to fetch custom domain",
+		"project", projectName,
+		"hostname", hostname,
+		"error", err,
+	)
+	http.Error(w, "Unable to fetch custom domain", http.StatusInternalServerError)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDomainFromPath(t *testing.T) {
+	tests := []struct {
+		project      string
+		hostname     string
+		wantHostname string
+		valid        bool
+	}{
+		{"acme", "hooks.acme.com", "hooks.acme.com", true},
+		{"acme", "Hooks.ACME.com.", "hooks.acme.com", true},
+		{"acme", "hooks.acme.com:443", "hooks.acme.com", true},
+		{"acme", "hooks..acme.com", "", false},
+		{"acme", "hooks_acme.com", "", false},
+		{"acme", "", "", false},
+		{"Acme", "hooks.acme.com", "", false},
+		{"-acme", "hooks.acme.com", "", false},
+		{"", "hooks.acme.com", "", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("project", tt.project)
+		r.SetPathValue("hostname", tt.hostname)
+		w := httptest.NewRecorder()
+
+		project, hostname, ok := domainFromPath(w, r)
+		if ok != tt.valid {
+			t.Errorf("domainFromPath(%q, %q) ok = %v, want %v", tt.project, tt.hostname, ok, tt.valid)
+			continue
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("domainFromPath(%q, %q) answered %d, want 400", tt.project, tt.hostname, w.Code)
+			}
+			continue
+		}
+		if project != tt.project || hostname != tt.wantHostname {
+			t.Errorf("domainFromPath(%q, %q) = %q, %q; want %q, %q", tt.project, tt.hostname, project, hostname, tt.project, tt.wantHostname)
+		}
+	}
+}
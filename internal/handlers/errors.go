@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/requestid"
+)
+
+var (
+	// ErrRelayUnreachable means the relay couldn't be connected to or dropped
+	// the connection before answering.
+	ErrRelayUnreachable = errors.New("relay unreachable")
+	// ErrRelayTimeout means the relay accepted the connection but didn't
+	// answer in time.
+	ErrRelayTimeout = errors.New("relay timed out")
+	// ErrStorage means a webhook couldn't be recorded, so it can't be
+	// acknowledged.
+	ErrStorage = errors.New("storage unavailable")
+	// ErrSettingsUnavailable means a project's settings couldn't be loaded,
+	// so whether its webhooks must be verified isn't known.
+	ErrSettingsUnavailable = errors.New("project settings unavailable")
+)
+
+// RelayError describes a failed attempt to reach a specific relay. Kind is
+// ErrRelayUnreachable or ErrRelayTimeout.
+type RelayError struct {
+	RelayID string
+	Kind    error
+	Err     error
+}
+
+func (e *RelayError) Error() string {
+	return fmt.Sprintf("relay %s: %v: %v", e.RelayID, e.Kind, e.Err)
+}
+
+func (e *RelayError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// newRelayError classifies a transport error as a timeout or an unreachable
+// relay.
+func newRelayError(relayID string, err error) *RelayError {
+	kind := ErrRelayUnreachable
+
+	var netErr net.Error
+	if errors.Is(err, errResponseHeaderTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		kind = ErrRelayTimeout
+	}
+
+	return &RelayError{RelayID: relayID, Kind: kind, Err: err}
+}
+
+// errorResponse is how an error is presented to webhook senders.
+type errorResponse struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+var errorResponses = []struct {
+	err  error
+	resp errorResponse
+}{
+	{conductor.ErrNoRelays, errorResponse{http.StatusServiceUnavailable, "no_relays", "No relay is available", 30 * time.Second}},
+	{conductor.ErrProjectNotAssigned, errorResponse{http.StatusServiceUnavailable, "project_not_connected", "The project has no relay connected", 30 * time.Second}},
+	{conductor.ErrRelayGone, errorResponse{http.StatusServiceUnavailable, "relay_gone", "The project's relay has gone away", 15 * time.Second}},
+	{conductor.ErrRegistryUnavailable, errorResponse{http.StatusServiceUnavailable, "registry_unavailable", "Relay registry is unavailable", 5 * time.Second}},
+	{ErrRelayTimeout, errorResponse{http.StatusGatewayTimeout, "relay_timeout", "The relay did not respond in time", 10 * time.Second}},
+	{ErrRelayUnreachable, errorResponse{http.StatusBadGateway, "relay_unreachable", "The relay could not be reached", 10 * time.Second}},
+	{ErrStorage, errorResponse{http.StatusServiceUnavailable, "storage_unavailable", "The webhook could not be stored", 5 * time.Second}},
+	{ErrSettingsUnavailable, errorResponse{http.StatusServiceUnavailable, "settings_unavailable", "The project's settings could not be loaded", 5 * time.Second}},
+	{ErrDispatchQueueFull, errorResponse{http.StatusServiceUnavailable, "delivery_queue_full", "Too many webhooks are waiting for delivery", 5 * time.Second}},
+}
+
+func classifyError(err error) errorResponse {
+	for _, e := range errorResponses {
+		if errors.Is(err, e.err) {
+			return e.resp
+		}
+	}
+	return errorResponse{http.StatusInternalServerError, "internal_error", "Internal server error", 0}
+}
+
+// writeError answers a webhook sender with a JSON error envelope, telling it
+// when to retry if the failure is likely to be temporary.
+func writeError(w http.ResponseWriter, logger *slog.Logger, requestID string, err error) {
+	resp := classifyError(err)
+
+	if resp.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(resp.retryAfter.Seconds())))
+	}
+	if requestID != "" {
+		w.Header().Set(requestid.Header, requestID)
+	}
+
+	body := struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id,omitempty"`
+		} `json:"error"`
+	}{}
+	body.Error.Code = resp.code
+	body.Error.Message = resp.message
+	body.Error.RequestID = requestID
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(resp.status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error("failed to encode error response", "error", err)
+	}
+}
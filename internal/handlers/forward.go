@@ -1,69 +1,513 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/whookdev/conductor/internal/clientip"
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/relaytls"
+	"github.com/whookdev/conductor/internal/requestid"
+	"github.com/whookdev/conductor/pkg/relayauth"
+)
+
+// Headers identifying a forwarded webhook to the relay. They replace the
+// project query parameter, which collided with senders' own parameters. The
+// request ID travels in requestid.Header.
+const (
+	projectHeader    = "X-Whook-Project"
+	generationHeader = "X-Whook-Assignment-Generation"
 )
 
-func ForwardRequest(w http.ResponseWriter, r *http.Request, relayURL string, baseDomain string, logger *slog.Logger) {
-	logger = logger.With("component", "forward_request")
+// Delivery describes a webhook being forwarded and the relay it is going to.
+// Zero timeouts fall back to the configured defaults.
+type Delivery struct {
+	Relay      *conductor.ServerInfo
+	Request    *models.StoredRequest
+	Generation int64
+
+	HeaderTimeout time.Duration
+	StreamTimeout time.Duration
+}
+
+// ApplyTimeouts applies a project's timeout overrides to a delivery.
+func (d *Delivery) ApplyTimeouts(t *models.TimeoutSettings) {
+	if t == nil {
+		return
+	}
+	d.HeaderTimeout = t.ResponseHeader(0)
+	d.StreamTimeout = t.Stream(0)
+}
+
+func (d Delivery) headerTimeout(cfg *config.Config) time.Duration {
+	if d.HeaderTimeout > 0 {
+		return d.HeaderTimeout
+	}
+	return cfg.RelayResponseHeaderTimeout
+}
+
+func (d Delivery) streamTimeout(cfg *config.Config) time.Duration {
+	if d.StreamTimeout > 0 {
+		return d.StreamTimeout
+	}
+	return cfg.RelayStreamTimeout
+}
+
+// DeliveryStore persists the outcome of deliveries against the stored
+// request: every attempt, and the move to the dead-letter queue if the
+// request never reaches a relay.
+type DeliveryStore interface {
+	RecordAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error
+	DeadLetter(ctx context.Context, dl *models.DeadLetter) error
+}
+
+type relayTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+// Forwarder proxies webhooks to relays. It keeps one long-lived transport per
+// relay so connections are pooled across requests.
+type Forwarder struct {
+	cfg      *config.Config
+	signer   *relayauth.Signer
+	relayTLS *relaytls.Manager
+	policy   *RetryPolicy
+	store    DeliveryStore
+	logger   *slog.Logger
 
-	if !strings.HasPrefix(relayURL, "http://") && !strings.HasPrefix(relayURL, "https://") {
-		relayURL = "http://" + relayURL
+	mu         sync.Mutex
+	transports map[string]*relayTransport
+	lastSweep  time.Time
+}
+
+func NewForwarder(cfg *config.Config, signer *relayauth.Signer, relayTLS *relaytls.Manager, store DeliveryStore, logger *slog.Logger) *Forwarder {
+	return &Forwarder{
+		cfg:        cfg,
+		signer:     signer,
+		relayTLS:   relayTLS,
+		policy:     NewRetryPolicy(cfg),
+		store:      store,
+		logger:     logger.With("component", "forwarder"),
+		transports: make(map[string]*relayTransport),
+		lastSweep:  time.Now(),
 	}
+}
+
+// Policy returns the retry policy applied to deliveries.
+func (f *Forwarder) Policy() *RetryPolicy {
+	return f.policy
+}
 
-	// Get the project name from the host
-	projectName := strings.TrimSuffix(r.Host, "."+baseDomain)
+// Forward proxies r to the relay, streaming the relay's response back to w.
+// The request body is taken from the delivery, which already buffered it.
+// Failed attempts are retried while the sync retry budget allows. Streamed
+// responses are flushed as they arrive, trailers included, for up to the
+// delivery's stream timeout.
+func (f *Forwarder) Forward(w http.ResponseWriter, r *http.Request, d Delivery) {
+	relay := d.Relay
+	logger := f.logger.With("project", d.Request.ProjectName, "relay_id", relay.ID)
 
-	// Create the target URL with project query parameter
-	targetURL := fmt.Sprintf("%s%s", relayURL, r.URL.RequestURI())
-	if strings.Contains(targetURL, "?") {
-		targetURL += "&project=" + projectName
-	} else {
-		targetURL += "?project=" + projectName
+	// The server's own timeouts are sized for short webhook exchanges and
+	// would cut off a relay that streams its response.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(d.streamTimeout(f.cfg))
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.WarnContext(r.Context(), "unable to extend write deadline", "error", err)
+	}
+	if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.WarnContext(r.Context(), "unable to extend read deadline", "error", err)
 	}
 
-	proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
+	target, err := f.relayURL(relay)
 	if err != nil {
-		logger.Error("failed to create proxy request", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		logger.ErrorContext(r.Context(), "invalid relay url", "relay_url", relay.RelayUrl, "error", err)
+		f.deadLetter(r.Context(), d, models.DeadLetterMisconfigured, 0, 0, err)
+		writeError(w, logger, d.Request.ID, newRelayError(relay.ID, err))
 		return
 	}
 
-	for name, values := range r.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(name, value)
-		}
+	transport, err := f.transportFor(relay, target, false)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "unable to secure relay connection", "error", err)
+		f.deadLetter(r.Context(), d, models.DeadLetterMisconfigured, 0, 0, err)
+		writeError(w, logger, d.Request.ID, newRelayError(relay.ID, err))
+		return
+	}
+
+	attempts := 0
+	retrying := &retryTransport{
+		base:     &headerTimeoutTransport{base: transport, timeout: d.headerTimeout(f.cfg)},
+		policy:   f.policy,
+		deadline: time.Now().Add(f.cfg.RelayRetrySyncBudget),
+		onAttempt: func(attempt int, started time.Time, resp *http.Response, err error) {
+			attempts = attempt
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode
+			}
+			f.recordAttempt(r.Context(), d, models.DeliveryModeSync, attempt, started, statusCode, err)
+		},
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport:     retrying,
+		FlushInterval: f.cfg.RelayFlushInterval,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			f.rewrite(pr, target, d)
+		},
+		// A retryable status that survives the retry budget means the relay
+		// never took the webhook, even though its response is passed on.
+		ModifyResponse: func(resp *http.Response) error {
+			if f.policy.Retryable(r.Context(), resp.StatusCode, nil) {
+				f.deadLetter(r.Context(), d, models.DeadLetterRetriesExhausted, attempts, resp.StatusCode, nil)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.ErrorContext(r.Context(), "failed to forward request", "error", err)
+			if r.Context().Err() == nil {
+				f.deadLetter(r.Context(), d, models.DeadLetterRetriesExhausted, attempts, 0, err)
+			}
+			writeError(w, logger, d.Request.ID, newRelayError(relay.ID, err))
+		},
 	}
 
-	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
-	proxyReq.Header.Set("X-Original-URL", r.URL.String())
+	proxy.ServeHTTP(w, r)
+}
 
-	proxyReq.Header.Set("X-Received-At", fmt.Sprintf("%d", time.Now().Unix()))
+// Deliver makes a single attempt to send a stored request to the relay,
+// outside of any inbound connection. in carries the sender's original URI
+// and headers; the relay's response body is discarded.
+func (f *Forwarder) Deliver(ctx context.Context, in *http.Request, d Delivery, attempt int) (int, error) {
+	started := time.Now()
+	statusCode, err := f.deliver(ctx, in, d)
+	f.recordAttempt(ctx, d, models.DeliveryModeAsync, attempt, started, statusCode, err)
+	return statusCode, err
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
+func (f *Forwarder) deliver(ctx context.Context, in *http.Request, d Delivery) (int, error) {
+	target, err := f.relayURL(d.Relay)
 	if err != nil {
-		logger.Error("failed to forward request", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return 0, newRelayError(d.Relay.ID, fmt.Errorf("invalid relay url: %w", err))
+	}
+
+	transport, err := f.transportFor(d.Relay, target, false)
+	if err != nil {
+		return 0, newRelayError(d.Relay.ID, fmt.Errorf("unable to secure relay connection: %w", err))
+	}
+
+	out := in.Clone(ctx)
+	out.RequestURI = ""
+	out.Close = false
+	removeHopHeaders(out.Header)
+
+	f.rewrite(&httputil.ProxyRequest{In: in, Out: out}, target, d)
+
+	rt := &headerTimeoutTransport{base: transport, timeout: d.headerTimeout(f.cfg)}
+	resp, err := rt.RoundTrip(out)
+	if err != nil {
+		return 0, newRelayError(d.Relay.ID, err)
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	return resp.StatusCode, nil
+}
+
+// hopHeaders are stripped from requests built outside ReverseProxy, matching
+// what it removes itself.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
 
-	for name, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(name, value)
+// removeHopHeaders deletes the hop-by-hop headers from h, along with any the
+// Connection header names as belonging to that hop.
+func removeHopHeaders(h http.Header) {
+	for _, field := range h["Connection"] {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
 		}
 	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func (f *Forwarder) recordAttempt(ctx context.Context, d Delivery, mode string, attempt int, started time.Time, statusCode int, err error) {
+	a := &models.DeliveryAttempt{
+		RequestID:   d.Request.ID,
+		ProjectName: d.Request.ProjectName,
+		Attempt:     attempt,
+		Mode:        mode,
+		StartedAt:   started,
+		DurationMs:  time.Since(started).Milliseconds(),
+		StatusCode:  statusCode,
+	}
+	if d.Relay != nil {
+		a.RelayID = d.Relay.ID
+	}
+
+	outcome := "delivered"
+	if err != nil {
+		a.Error = err.Error()
+		outcome = "error"
+	} else if statusCode >= http.StatusInternalServerError {
+		outcome = "failed"
+	}
+	deliveryAttempts.WithLabelValues(mode, outcome).Inc()
 
-	w.WriteHeader(resp.StatusCode)
+	if outcome != "delivered" {
+		f.logger.WarnContext(ctx, "delivery attempt failed",
+			"project", a.ProjectName,
+			"request_id", a.RequestID,
+			"relay_id", a.RelayID,
+			"mode", mode,
+			"attempt", attempt,
+			"status", statusCode,
+			"error", a.Error)
+	}
+
+	if f.store == nil {
+		return
+	}
+	// The attempt is worth keeping even if the sender has gone away.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
 
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		logger.Error("failed to copy response body", "error", err)
+	if err := f.store.RecordAttempt(ctx, a); err != nil {
+		f.logger.ErrorContext(ctx, "failed to record delivery attempt",
+			"request_id", a.RequestID,
+			"attempt", attempt,
+			"error", err)
+	}
+}
+
+// deadLetter moves a request that couldn't be delivered to the dead-letter
+// queue so it can be inspected and redriven later.
+func (f *Forwarder) deadLetter(ctx context.Context, d Delivery, reason string, attempts, statusCode int, err error) {
+	if f.store == nil {
 		return
 	}
+
+	dl := &models.DeadLetter{
+		RequestID:   d.Request.ID,
+		ProjectName: d.Request.ProjectName,
+		Reason:      reason,
+		Attempts:    attempts,
+		LastStatus:  statusCode,
+		DeadAt:      time.Now(),
+	}
+	if err != nil {
+		dl.LastError = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := f.store.DeadLetter(ctx, dl); err != nil {
+		f.logger.ErrorContext(ctx, "failed to dead-letter request",
+			"project", dl.ProjectName,
+			"request_id", dl.RequestID,
+			"reason", reason,
+			"error", err)
+	}
+}
+
+func (f *Forwarder) rewrite(pr *httputil.ProxyRequest, target *url.URL, d Delivery) {
+	body := d.Request.Body
+
+	pr.SetURL(target)
+
+	// Keep the URI exactly as the sender wrote it. Signature schemes often
+	// cover it, and ReverseProxy may otherwise re-encode the query.
+	if opaque, query := relayRequestURI(target, pr.In); !strings.HasPrefix(opaque, "//") {
+		pr.Out.URL.Opaque = opaque
+		pr.Out.URL.RawQuery = query
+	}
+
+	if f.cfg.RelayLegacyProjectQuery {
+		projectParam := "project=" + url.QueryEscape(d.Request.ProjectName)
+		if pr.Out.URL.RawQuery == "" {
+			pr.Out.URL.RawQuery = projectParam
+		} else {
+			pr.Out.URL.RawQuery += "&" + projectParam
+		}
+	}
+
+	pr.Out.Header.Set(projectHeader, d.Request.ProjectName)
+	pr.Out.Header.Set(requestid.Header, d.Request.ID)
+	pr.Out.Header.Set(generationHeader, strconv.FormatInt(d.Generation, 10))
+
+	// Drop inbound forwarding headers, as ReverseProxy does, so requests
+	// built outside it can't carry a chain forged by the sender. Keep the
+	// chain when it was built by a proxy we trust so relays see the
+	// original client.
+	for _, name := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+		pr.Out.Header.Del(name)
+	}
+	trusted := clientip.Trusted(f.cfg.TrustedProxies, pr.In.RemoteAddr)
+	if trusted {
+		if prior := pr.In.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			pr.Out.Header["X-Forwarded-For"] = prior
+		}
+	}
+	pr.SetXForwarded()
+	if proto := pr.In.Header.Get("X-Forwarded-Proto"); trusted && proto != "" {
+		pr.Out.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	pr.Out.Header.Set("X-Original-URL", pr.In.URL.String())
+	pr.Out.Header.Set("X-Received-At", fmt.Sprintf("%d", time.Now().Unix()))
+
+	pr.Out.Body = io.NopCloser(bytes.NewReader(body))
+	pr.Out.ContentLength = int64(len(body))
+	pr.Out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	if f.signer != nil {
+		f.signer.Sign(pr.Out, body, time.Now())
+	}
+}
+
+// relayRequestURI joins the relay URL's path with the sender's raw request
+// URI, returning the path and query separately.
+func relayRequestURI(target *url.URL, in *http.Request) (string, string) {
+	path, query := in.URL.EscapedPath(), in.URL.RawQuery
+	if strings.HasPrefix(in.RequestURI, "/") {
+		path, query, _ = strings.Cut(in.RequestURI, "?")
+	}
+
+	if target.RawQuery != "" {
+		query = strings.TrimSuffix(target.RawQuery+"&"+query, "&")
+	}
+
+	return strings.TrimSuffix(target.EscapedPath(), "/") + path, query
+}
+
+func (f *Forwarder) relayURL(relay *conductor.ServerInfo) (*url.URL, error) {
+	raw := relay.RelayUrl
+	if !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+		if f.useTLS(relay) {
+			raw = "https://" + raw
+		} else {
+			raw = "http://" + raw
+		}
+	}
+
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if target.Host == "" {
+		return nil, errors.New("relay url has no host")
+	}
+
+	return target, nil
+}
+
+// useTLS reports whether a relay should be contacted over TLS when its URL
+// doesn't say. A relay that advertises a certificate fingerprint always is,
+// so the pin can be enforced.
+func (f *Forwarder) useTLS(relay *conductor.ServerInfo) bool {
+	return f.relayTLS.Enabled() || relay.CertFingerprint != ""
+}
+
+// transportFor returns the pooled transport for a relay. Over TLS, HTTP/2 is
+// negotiated as usual; over plain http it is only used when the relay
+// advertises h2c, and never for upgrades, which need HTTP/1.1.
+func (f *Forwarder) transportFor(relay *conductor.ServerInfo, target *url.URL, upgrade bool) (*http.Transport, error) {
+	var tlsConfig *tls.Config
+	if target.Scheme == "https" && f.useTLS(relay) {
+		tlsConfig = f.relayTLS.ClientConfig(relay.CertFingerprint)
+	} else if relay.CertFingerprint != "" {
+		return nil, errors.New("relay advertises a certificate fingerprint but a plain http url")
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	if target.Scheme == "http" && !upgrade && relay.Supports(conductor.ProtocolH2C) {
+		// Prior-knowledge h2c is only used when HTTP/1 is switched off.
+		protocols.SetHTTP1(false)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	key := relay.ID + "|" + target.Scheme + "://" + target.Host + "|" + relay.CertFingerprint + "|" + protocols.String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.sweepLocked(now)
+
+	if rt, ok := f.transports[key]; ok {
+		rt.lastUsed = now
+		return rt.transport, nil
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   f.cfg.RelayDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   f.cfg.RelayTLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConnsPerHost:   f.cfg.RelayMaxIdleConnsPerHost,
+		IdleConnTimeout:       f.cfg.RelayIdleConnTimeout,
+		Protocols:             protocols,
+	}
+
+	f.transports[key] = &relayTransport{transport: transport, lastUsed: now}
+	f.logger.Info("created relay transport",
+		"relay_id", relay.ID,
+		"relay_host", target.Host,
+		"protocols", protocols.String())
+
+	return transport, nil
+}
+
+// sweepLocked drops transports for relays that haven't been used for a while,
+// which covers relays that have died or been reassigned.
+func (f *Forwarder) sweepLocked(now time.Time) {
+	idle := f.cfg.RelayIdleConnTimeout
+	if now.Sub(f.lastSweep) < idle {
+		return
+	}
+	f.lastSweep = now
+
+	for key, rt := range f.transports {
+		if now.Sub(rt.lastUsed) > idle {
+			rt.transport.CloseIdleConnections()
+			delete(f.transports, key)
+		}
+	}
 }
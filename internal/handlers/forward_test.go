@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/relaytls"
+	"github.com/whookdev/conductor/pkg/relayauth"
+)
+
+// forwardRequestBaseline is ForwardRequest as it stood before the Forwarder
+// replaced it, copied verbatim apart from the name, so the benchmark measures
+// against the code that actually shipped. It builds a new http.Client for
+// every webhook: over plain http that still pools connections through
+// http.DefaultTransport, but over TLS it clones a transport with keep-alives
+// off, so every request dials and handshakes from scratch.
+func forwardRequestBaseline(w http.ResponseWriter, r *http.Request, relayURL string, baseDomain string, signer *relayauth.Signer, tlsConfig *tls.Config, logger *slog.Logger) {
+	logger = logger.With("component", "forward_request")
+
+	if !strings.HasPrefix(relayURL, "http://") && !strings.HasPrefix(relayURL, "https://") {
+		if tlsConfig != nil {
+			relayURL = "https://" + relayURL
+		} else {
+			relayURL = "http://" + relayURL
+		}
+	}
+
+	// Get the project name from the host
+	projectName := strings.TrimSuffix(r.Host, "."+baseDomain)
+
+	// Create the target URL with project query parameter
+	targetURL := fmt.Sprintf("%s%s", relayURL, r.URL.RequestURI())
+	if strings.Contains(targetURL, "?") {
+		targetURL += "&project=" + projectName
+	} else {
+		targetURL += "?project=" + projectName
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("failed to read request body", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	proxyReq, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("failed to create proxy request", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	for name, values := range r.Header {
+		for _, value := range values {
+			proxyReq.Header.Add(name, value)
+		}
+	}
+
+	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
+	proxyReq.Header.Set("X-Original-URL", r.URL.String())
+
+	proxyReq.Header.Set("X-Received-At", fmt.Sprintf("%d", time.Now().Unix()))
+
+	if signer != nil {
+		signer.Sign(proxyReq, body, time.Now())
+	}
+
+	client := &http.Client{}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		transport.DisableKeepAlives = true
+		client.Transport = transport
+	}
+	resp, err := client.Do(proxyReq)
+	if err != nil {
+		logger.Error("failed to forward request", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.Error("failed to copy response body", "error", err)
+		return
+	}
+}
+
+func newBenchRelay(b *testing.B, useTLS bool) (*httptest.Server, *config.Config) {
+	b.Helper()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("ok"))
+	})
+
+	cfg := &config.Config{
+		RelayDialTimeout:           5 * time.Second,
+		RelayTLSHandshakeTimeout:   5 * time.Second,
+		RelayResponseHeaderTimeout: 10 * time.Second,
+		RelayIdleConnTimeout:       90 * time.Second,
+		RelayMaxIdleConnsPerHost:   64,
+		RelayFlushInterval:         100 * time.Millisecond,
+		RelayStreamTimeout:         time.Minute,
+		RelayRetryMaxAttempts:      1,
+		RelayRetrySyncBudget:       10 * time.Second,
+		RelayTLSReloadInterval:     time.Minute,
+	}
+
+	if !useTLS {
+		relay := httptest.NewServer(handler)
+		b.Cleanup(relay.Close)
+		return relay, cfg
+	}
+
+	relay := httptest.NewTLSServer(handler)
+	b.Cleanup(relay.Close)
+
+	caFile := filepath.Join(b.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: relay.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		b.Fatal(err)
+	}
+	cfg.RelayTLSCAFile = caFile
+
+	return relay, cfg
+}
+
+// BenchmarkForward compares the Forwarder with the code it replaced. Over TLS
+// the baseline handshakes on every request and the Forwarder doesn't, which is
+// where pooling pays off. Over plain http both reuse connections, so the
+// Forwarder can't win there; it should stay close while paying for retries,
+// attempt records and streamed responses.
+func BenchmarkForward(b *testing.B) {
+	body := bytes.Repeat([]byte(`{"event":"ping"}`), 64)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	keyring, err := relayauth.NewKeyring("k1", map[string][]byte{"k1": []byte("bench-secret")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	signer := relayauth.NewSigner(keyring)
+
+	for _, useTLS := range []bool{false, true} {
+		scheme := "http"
+		if useTLS {
+			scheme = "https"
+		}
+
+		b.Run(scheme+"/pooled", func(b *testing.B) {
+			relay, cfg := newBenchRelay(b, useTLS)
+			relayTLS, err := relaytls.New(cfg, logger)
+			if err != nil {
+				b.Fatal(err)
+			}
+			f := NewForwarder(cfg, signer, relayTLS, nil, logger)
+
+			d := Delivery{
+				Relay: &conductor.ServerInfo{ID: "relay-1", RelayUrl: relay.URL},
+				Request: &models.StoredRequest{
+					ID:          "req-1",
+					ProjectName: "bench",
+					Body:        body,
+				},
+				Generation: 1,
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodPost, "/hook?attempt=1", bytes.NewReader(body))
+				w := httptest.NewRecorder()
+				f.Forward(w, r, d)
+				if w.Code != http.StatusOK {
+					b.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+				}
+			}
+		})
+
+		b.Run(scheme+"/baseline", func(b *testing.B) {
+			relay, cfg := newBenchRelay(b, useTLS)
+			relayTLS, err := relaytls.New(cfg, logger)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var tlsConfig *tls.Config
+			if useTLS {
+				tlsConfig = relayTLS.ClientConfig("")
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodPost, "http://bench.hooks.test/hook?attempt=1", bytes.NewReader(body))
+				w := httptest.NewRecorder()
+				forwardRequestBaseline(w, r, relay.URL, "hooks.test", signer, tlsConfig, logger)
+				if w.Code != http.StatusOK {
+					b.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestForwardRewrite(t *testing.T) {
+	type seen struct {
+		uri    string
+		header http.Header
+	}
+	got := make(chan seen, 1)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		got <- seen{r.RequestURI, r.Header.Clone()}
+	}))
+	defer relay.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		RelayDialTimeout:           5 * time.Second,
+		RelayResponseHeaderTimeout: 5 * time.Second,
+		RelayIdleConnTimeout:       time.Minute,
+		RelayStreamTimeout:         time.Minute,
+		RelayRetryMaxAttempts:      1,
+		RelayTLSReloadInterval:     time.Minute,
+		TrustedProxies:             []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	relayTLS, err := relaytls.New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewForwarder(cfg, nil, relayTLS, nil, logger)
+	d := Delivery{
+		Relay:      &conductor.ServerInfo{ID: "relay-1", RelayUrl: relay.URL + "/base"},
+		Request:    &models.StoredRequest{ID: "req-1", ProjectName: "acme", Body: []byte("{}")},
+		Generation: 1,
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		uri        string
+		header     http.Header
+		wantURI    string
+		want       http.Header
+		absent     []string
+	}{
+		{
+			name:       "hop-by-hop headers",
+			remoteAddr: "198.51.100.9:4000",
+			uri:        "/hook",
+			header: http.Header{
+				"Connection":          {"X-Hop, Keep-Alive"},
+				"Keep-Alive":          {"timeout=5"},
+				"X-Hop":               {"1"},
+				"Proxy-Authorization": {"Basic c2VjcmV0"},
+				"Upgrade":             {"h2c"},
+				"X-Keep":              {"1"},
+			},
+			wantURI: "/base/hook",
+			want:    http.Header{"X-Keep": {"1"}},
+			absent:  []string{"X-Hop", "Keep-Alive", "Proxy-Authorization", "Upgrade"},
+		},
+		{
+			name:       "trusted peer",
+			remoteAddr: "10.1.2.3:4000",
+			uri:        "/hook",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantURI: "/base/hook",
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7, 10.1.2.3"},
+				"X-Forwarded-Proto": {"https"},
+			},
+		},
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.9:4000",
+			uri:        "/hook",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantURI: "/base/hook",
+			want: http.Header{
+				"X-Forwarded-For":   {"198.51.100.9"},
+				"X-Forwarded-Proto": {"http"},
+			},
+		},
+		{
+			name:       "raw request uri",
+			remoteAddr: "198.51.100.9:4000",
+			uri:        "/hook/a%2Fb;v=1?x=%7e&y=a+b&y=%41&z",
+			wantURI:    "/base/hook/a%2Fb;v=1?x=%7e&y=a+b&y=%41&z",
+		},
+	}
+
+	for _, tt := range tests {
+		newRequest := func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, tt.uri, strings.NewReader("{}"))
+			r.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			return r
+		}
+		check := func(t *testing.T) {
+			s := <-got
+			if s.uri != tt.wantURI {
+				t.Errorf("relay saw request URI %q, want %q", s.uri, tt.wantURI)
+			}
+			for name, values := range tt.want {
+				if g := strings.Join(s.header.Values(name), ", "); g != strings.Join(values, ", ") {
+					t.Errorf("relay saw %s %q, want %q", name, g, strings.Join(values, ", "))
+				}
+			}
+			for _, name := range tt.absent {
+				if v := s.header.Get(name); v != "" {
+					t.Errorf("relay saw %s %q, want it stripped", name, v)
+				}
+			}
+		}
+
+		t.Run(tt.name+"/forward", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			f.Forward(w, newRequest(), d)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+			}
+			check(t)
+		})
+
+		t.Run(tt.name+"/deliver", func(t *testing.T) {
+			if _, err := f.Deliver(context.Background(), newRequest(), d, 1); err != nil {
+				t.Fatal(err)
+			}
+			check(t)
+		})
+	}
+}
+
+// recordingStore keeps the attempts and dead letters a Forwarder records.
+type recordingStore struct {
+	mu          sync.Mutex
+	attempts    []*models.DeliveryAttempt
+	deadLetters []*models.DeadLetter
+}
+
+func (s *recordingStore) RecordAttempt(_ context.Context, a *models.DeliveryAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, a)
+	return nil
+}
+
+func (s *recordingStore) DeadLetter(_ context.Context, dl *models.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetters = append(s.deadLetters, dl)
+	return nil
+}
+
+func TestForwardMisconfiguredRelay(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{RelayTLSReloadInterval: time.Minute, RelayStreamTimeout: time.Minute}
+	relayTLS, err := relaytls.New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		relay *conductor.ServerInfo
+	}{
+		{"invalid url", &conductor.ServerInfo{ID: "relay-1", RelayUrl: "http://"}},
+		{"pinned relay over plain http", &conductor.ServerInfo{ID: "relay-1", RelayUrl: "http://relay.internal", CertFingerprint: "ab12"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &recordingStore{}
+			f := NewForwarder(cfg, nil, relayTLS, store, logger)
+			d := Delivery{Relay: tt.relay, Request: &models.StoredRequest{ID: "req-1", ProjectName: "acme"}}
+
+			w := httptest.NewRecorder()
+			f.Forward(w, httptest.NewRequest(http.MethodPost, "/hook", nil), d)
+
+			if w.Code != http.StatusBadGateway {
+				t.Fatalf("status = %d, want 502", w.Code)
+			}
+			if len(store.deadLetters) != 1 {
+				t.Fatalf("%d dead letters, want 1", len(store.deadLetters))
+			}
+			if dl := store.deadLetters[0]; dl.Reason != models.DeadLetterMisconfigured || dl.Attempts != 0 {
+				t.Fatalf("dead letter reason %q after %d attempts, want %q after none", dl.Reason, dl.Attempts, models.DeadLetterMisconfigured)
+			}
+		})
+	}
+}
@@ -1,55 +1,108 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/whookdev/conductor/internal/conductor"
 	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/dedup"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/requestid"
+	"github.com/whookdev/conductor/internal/routing"
+	"github.com/whookdev/conductor/internal/signature"
 	"github.com/whookdev/conductor/internal/storage"
+	"github.com/whookdev/conductor/pkg/relayauth"
 )
 
+// signatureResultHeader tells relays whether the sender's signature was
+// verified when a project tags rather than rejects failed verifications.
+const signatureResultHeader = "X-Whook-Signature-Verified"
+
 type ProjectHandler struct {
-	cfg       *config.Config
-	conductor *conductor.Conductor
-	storage   *storage.RequestStorage
-	logger    *slog.Logger
+	cfg        *config.Config
+	conductor  *conductor.Conductor
+	storage    *storage.RequestStorage
+	settings   *projects.SettingsStore
+	dedup      *dedup.Deduplicator
+	forwarder  *Forwarder
+	dispatcher *Dispatcher
+	tokens     *relayauth.TokenSigner
+	logger     *slog.Logger
 }
 
-func NewProjectHandler(cfg *config.Config, c *conductor.Conductor, s *storage.RequestStorage, logger *slog.Logger) *ProjectHandler {
+// NewProjectHandler creates the handler for webhooks and relay assignments.
+// tokens may be nil, in which case assignments carry no token.
+func NewProjectHandler(cfg *config.Config, c *conductor.Conductor, s *storage.RequestStorage, ss *projects.SettingsStore, d *dedup.Deduplicator, f *Forwarder, dp *Dispatcher, tokens *relayauth.TokenSigner, logger *slog.Logger) *ProjectHandler {
 	return &ProjectHandler{
-		cfg:       cfg,
-		conductor: c,
-		storage:   s,
-		logger:    logger.With("component", "project_handler"),
+		cfg:        cfg,
+		conductor:  c,
+		storage:    s,
+		settings:   ss,
+		dedup:      d,
+		forwarder:  f,
+		dispatcher: dp,
+		tokens:     tokens,
+		logger:     logger.With("component", "project_handler"),
 	}
 }
 
+// HandleRelayAssignment returns the project's assignment, keeping a healthy
+// existing one unless force or prefer_relay asks for another relay.
 func (h *ProjectHandler) HandleRelayAssignment(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ProjectName string `json:"project_name"`
+		Force       bool   `json:"force"`
+		PreferRelay string `json:"prefer_relay"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Error("failed to decode request body", "error", err)
+		h.logger.ErrorContext(r.Context(), "failed to decode request body", "error", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
 	if req.ProjectName == "" {
-		h.logger.Error("missing project name in request")
+		h.logger.ErrorContext(r.Context(), "missing project name in request")
 		http.Error(w, "project_name is required", http.StatusBadRequest)
 		return
 	}
 
-	h.logger.Info("assigning relay", "project", req.ProjectName)
+	if err := routing.ValidateProjectName(req.ProjectName); err != nil {
+		h.logger.WarnContext(r.Context(), "invalid project name", "project", req.ProjectName, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "assigning relay",
+		"project", req.ProjectName,
+		"force", req.Force,
+		"prefer_relay", req.PreferRelay)
 
-	rAssignment, err := h.conductor.AssignRelayServer(req.ProjectName)
+	rAssignment, err := h.conductor.AssignRelayServer(req.ProjectName, conductor.AssignOptions{
+		Force:       req.Force,
+		PreferRelay: req.PreferRelay,
+	})
 	if err != nil {
-		h.logger.Error("unable to assign relay server",
+		h.logger.ErrorContext(r.Context(), "unable to assign relay server",
+			"project", req.ProjectName,
+			"error", err,
+		)
+		// No relays or an unreachable registry are temporary, so the client
+		// is told to retry rather than given a bare 500.
+		writeError(w, h.logger, "", err)
+		return
+	}
+
+	if err := h.signAssignment(req.ProjectName, rAssignment); err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to sign relay assignment",
 			"project", req.ProjectName,
 			"error", err,
 		)
@@ -59,70 +112,347 @@ func (h *ProjectHandler) HandleRelayAssignment(w http.ResponseWriter, r *http.Re
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(rAssignment); err != nil {
-		h.logger.Error("failed to encode response", "error", err)
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
+// HandleAssignmentHistory lists a project's recent assignments and why each
+// was made, newest first.
+func (h *ProjectHandler) HandleAssignmentHistory(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+
+	records, err := h.conductor.AssignmentHistory(projectName)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to fetch assignment history",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to fetch assignment history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, records)
+}
+
+// signAssignment attaches a token the relay can verify the CLI with.
+func (h *ProjectHandler) signAssignment(projectName string, assignment *models.RelayAssignment) error {
+	if h.tokens == nil {
+		return nil
+	}
+
+	token, expiresAt, err := h.tokens.Sign(projectName, assignment.RelayID, assignment.Generation, time.Now())
+	if err != nil {
+		return err
+	}
+	assignment.Token = token
+	assignment.TokenExpiresAt = &expiresAt
+	return nil
+}
+
+// HandleAssignmentKeys publishes the keys assignment tokens are signed with,
+// for relays to verify them.
+func (h *ProjectHandler) HandleAssignmentKeys(w http.ResponseWriter, r *http.Request) {
+	if h.tokens == nil {
+		http.Error(w, "Assignment tokens are not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, h.logger, http.StatusOK, h.tokens.Keyring().JWKS())
+}
+
 func (h *ProjectHandler) HandleProjectRequest(w http.ResponseWriter, r *http.Request) {
-	projectName := strings.TrimSuffix(r.Host, "."+h.cfg.BaseDomain)
-	h.logger.Info("handling project request",
+	route, ok := routing.FromContext(r.Context())
+	if !ok || route.Kind != routing.KindProject {
+		h.logger.ErrorContext(r.Context(), "project request without a resolved project", "host", r.Host)
+		http.Error(w, "Unknown project", http.StatusNotFound)
+		return
+	}
+	projectName := route.Project
+	h.logger.InfoContext(r.Context(), "handling project request",
 		"project", projectName,
 		"method", r.Method,
 		"path", r.URL.Path,
 	)
 
-	_, err := h.storage.StoreRequest(r, projectName)
+	storedReq, err := h.storage.Capture(r, projectName)
 	if err != nil {
-		h.logger.Error("failed to store request", "project", projectName, "error", err)
+		h.logger.ErrorContext(r.Context(), "failed to read request", "project", projectName, "error", err)
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
 	}
 
-	relayURL, err := h.conductor.GetProjectRelayServer(projectName)
+	settings, err := h.settings.Get(r.Context(), projectName)
 	if err != nil {
-		h.logger.Error("unable to get relay server",
+		h.logger.ErrorContext(r.Context(), "failed to load project settings", "project", projectName, "error", err)
+		// Without settings a project that rejects unsigned webhooks can't be
+		// told apart from one that doesn't verify at all, so nothing is
+		// forwarded and the sender retries.
+		writeError(w, h.logger, storedReq.ID, fmt.Errorf("%w: %w", ErrSettingsUnavailable, err))
+		return
+	}
+
+	// Relays trust this header, so a value supplied by the sender must never
+	// reach them.
+	r.Header.Del(signatureResultHeader)
+
+	if v := settings.Verification; v != nil {
+		result := h.verifySignature(r.Context(), v, r.Header, storedReq)
+		storedReq.SignatureStatus = string(result)
+
+		if result != signature.ResultValid && v.Rejects() {
+			if err := h.storage.Store(r.Context(), storedReq); err != nil {
+				h.logger.ErrorContext(r.Context(), "failed to store request", "project", projectName, "error", err)
+			}
+			http.Error(w, "Invalid webhook signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Header.Set(signatureResultHeader, string(result))
+	}
+
+	rule := settings.Dedup
+	duplicate := false
+	if rule != nil {
+		duplicate = h.checkDuplicate(r.Context(), rule, r.Header, storedReq)
+	}
+
+	storeErr := h.storage.Store(r.Context(), storedReq)
+	if storeErr != nil {
+		h.logger.ErrorContext(r.Context(), "failed to store request", "project", projectName, "error", storeErr)
+	}
+
+	if duplicate && rule.ReplayResponse {
+		if h.replayResponse(r.Context(), w, storedReq) {
+			return
+		}
+	}
+
+	// Upgrades need the relay on the other end of the connection, so they
+	// are never deferred.
+	upgrade := IsUpgrade(r)
+
+	if h.cfg.DeliveryMode == models.DeliveryModeAsync && !upgrade {
+		// Acknowledging a webhook that wasn't stored would lose it if the
+		// conductor restarts before delivering it.
+		if storeErr != nil {
+			writeError(w, h.logger, storedReq.ID, fmt.Errorf("%w: %w", ErrStorage, storeErr))
+			return
+		}
+		h.acceptForDelivery(w, r, storedReq)
+		return
+	}
+
+	relay, generation, err := h.conductor.GetProjectRelay(projectName)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to get relay server",
 			"project", projectName,
 			"error", err,
 		)
-		//TODO: This is a difficult one, if we return a non-200 most services will
-		//attempt to retry the webhook. If we return a 200 and have stored it we
-		//can replay the webhook and the caller only sees a success. Need to figure
-		//out what the ideal flow is here - is it better to 'lie' to the caller and
-		//claim everything is OK, or do we inform it that the downstream service
-		//was unreachable? If we 'lie', we can then store these messages in a queue
-		//for when a relay server is accessible again?
-		http.Error(w, "Unable to process request", http.StatusInternalServerError)
+		// Be honest with the sender so its own retries kick in. Each retry is
+		// stored as a new request, so the webhook is only dead-lettered for a
+		// redrive when the sender isn't told to retry; otherwise every retry
+		// would add another. Projects that would rather always get a 202 can
+		// use async delivery.
+		if classifyError(err).retryAfter == 0 {
+			h.forwarder.deadLetter(r.Context(), Delivery{Request: storedReq}, models.DeadLetterNoRelay, 0, 0, err)
+		}
+		writeError(w, h.logger, storedReq.ID, err)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "relay URL found", "relay_url", relay.RelayUrl)
+
+	delivery := Delivery{
+		Relay:      relay,
+		Request:    storedReq,
+		Generation: generation,
+	}
+	delivery.ApplyTimeouts(settings.Timeouts)
+
+	if upgrade {
+		h.forwarder.Upgrade(w, r, delivery)
+		return
+	}
+
+	if rule == nil || !rule.ReplayResponse || duplicate || storedReq.IdempotencyKey == "" {
+		h.forwarder.Forward(w, r, delivery)
 		return
 	}
 
-	h.logger.Info("relay URL found", "relay_url", relayURL)
+	recorder := newResponseRecorder(w)
+	h.forwarder.Forward(recorder, r, delivery)
 
-	ForwardRequest(w, r, relayURL, h.cfg.BaseDomain, h.logger)
+	// Only keep responses worth replaying; a 5xx should let the provider's
+	// retry reach the relay again.
+	if resp := recorder.Captured(); resp != nil && resp.StatusCode < http.StatusInternalServerError {
+		if err := h.dedup.SaveResponse(r.Context(), projectName, storedReq.IdempotencyKey, resp,
+			rule.Window(h.cfg.DedupDefaultWindow)); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to save response for replay",
+				"project", projectName,
+				"request_id", storedReq.ID,
+				"error", err)
+		}
+	}
 }
 
-func (h *ProjectHandler) HandleRelayRequest(w http.ResponseWriter, r *http.Request) {
-	projectName := r.URL.Query().Get("project_name")
-	if projectName == "" {
-		h.logger.Error("missing project name in request")
-		http.Error(w, "project_name is required", http.StatusBadRequest)
+// acceptForDelivery hands a stored request to the dispatcher and acknowledges
+// it to the sender without waiting for the relay.
+func (h *ProjectHandler) acceptForDelivery(w http.ResponseWriter, r *http.Request, storedReq *models.StoredRequest) {
+	if err := h.dispatcher.Enqueue(r, storedReq); err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to schedule delivery",
+			"project", storedReq.ProjectName,
+			"request_id", storedReq.ID,
+			"error", err)
+		writeError(w, h.logger, storedReq.ID, err)
 		return
 	}
-	h.logger.Info("assigning relay", "project", projectName)
 
-	rAssignment, err := h.conductor.GetProjectRelayServer(projectName)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]string{"request_id": storedReq.ID}); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
+	}
+}
+
+func (h *ProjectHandler) verifySignature(ctx context.Context, settings *models.VerificationSettings, header http.Header, storedReq *models.StoredRequest) signature.Result {
+	verifier, err := signature.New(settings)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "invalid verification settings",
+			"project", storedReq.ProjectName,
+			"scheme", settings.Scheme,
+			"error", err)
+		return signature.ResultInvalid
+	}
+
+	err = verifier.Verify(header, storedReq.Body, time.Now())
+	result := signature.ResultFor(err)
+	if err != nil {
+		h.logger.WarnContext(ctx, "webhook signature verification failed",
+			"project", storedReq.ProjectName,
+			"request_id", storedReq.ID,
+			"scheme", settings.Scheme,
+			"result", result,
+			"error", err)
+	}
+
+	return result
+}
+
+// checkDuplicate extracts the idempotency key for a request and claims it,
+// linking the request to the original delivery if it has been seen before.
+func (h *ProjectHandler) checkDuplicate(ctx context.Context, rule *models.DedupRule, header http.Header, storedReq *models.StoredRequest) bool {
+	key, ok := dedup.ExtractKey(rule, header, storedReq.Body)
+	if !ok {
+		return false
+	}
+	storedReq.IdempotencyKey = key
+
+	originalID, duplicate, err := h.dedup.Claim(ctx, storedReq.ProjectName, key, storedReq.ID,
+		rule.Window(h.cfg.DedupDefaultWindow))
 	if err != nil {
-		h.logger.Error("unable to assign relay server",
+		h.logger.ErrorContext(ctx, "failed to check idempotency key",
+			"project", storedReq.ProjectName,
+			"error", err)
+		return false
+	}
+
+	if duplicate {
+		storedReq.DuplicateOf = originalID
+		h.logger.InfoContext(ctx, "duplicate delivery",
+			"project", storedReq.ProjectName,
+			"request_id", storedReq.ID,
+			"duplicate_of", originalID,
+			"idempotency_key", key)
+	}
+
+	return duplicate
+}
+
+// replayResponse answers a duplicate with the response the original delivery
+// received. It reports false if no response has been saved yet, for example
+// because the original is still in flight.
+func (h *ProjectHandler) replayResponse(ctx context.Context, w http.ResponseWriter, storedReq *models.StoredRequest) bool {
+	resp, err := h.dedup.Response(ctx, storedReq.ProjectName, storedReq.IdempotencyKey)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to load response for replay",
+			"project", storedReq.ProjectName,
+			"request_id", storedReq.ID,
+			"error", err)
+		return false
+	}
+	if resp == nil {
+		return false
+	}
+
+	if err := writeReplay(w, resp, storedReq.DuplicateOf); err != nil {
+		h.logger.ErrorContext(ctx, "failed to write replayed response", "error", err)
+	}
+
+	h.logger.InfoContext(ctx, "replayed original response",
+		"project", storedReq.ProjectName,
+		"request_id", storedReq.ID,
+		"duplicate_of", storedReq.DuplicateOf)
+
+	return true
+}
+
+// writeReplay writes a saved response as the answer to a duplicate. Hop-by-hop
+// headers belonged to the original connection and the request ID to the
+// original request, so neither is replayed; the duplicate keeps its own ID.
+func writeReplay(w http.ResponseWriter, resp *models.CapturedResponse, duplicateOf string) error {
+	headers := resp.Headers.Clone()
+	removeHopHeaders(headers)
+	headers.Del(requestid.Header)
+	copyHeader(w.Header(), headers)
+	w.Header().Set("X-Whook-Duplicate-Of", duplicateOf)
+
+	w.WriteHeader(resp.StatusCode)
+	_, err := w.Write(resp.Body)
+	return err
+}
+
+// HandleGetRelay returns a project's current assignment so a CLI that lost
+// its relay can find where to reconnect. It only assigns a relay when there
+// is none, or the assigned one has gone, and create=true is passed. Clients
+// compare the generation with the one they hold to spot a reassignment.
+func (h *ProjectHandler) HandleGetRelay(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	if err := routing.ValidateProjectName(projectName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	create := r.URL.Query().Get("create") == "true"
+
+	assignment, err := h.conductor.CurrentAssignment(projectName)
+	if create && (errors.Is(err, conductor.ErrProjectNotAssigned) || errors.Is(err, conductor.ErrRelayGone)) {
+		h.logger.InfoContext(r.Context(), "assigning relay", "project", projectName, "reason", err)
+		assignment, err = h.conductor.AssignRelayServer(projectName, conductor.AssignOptions{})
+	}
+	if err != nil {
+		if errors.Is(err, conductor.ErrProjectNotAssigned) {
+			http.Error(w, "Project has no relay assigned", http.StatusNotFound)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "unable to look up relay assignment",
 			"project", projectName,
 			"error", err,
 		)
-		http.Error(w, "Unable to assign relay server", http.StatusInternalServerError)
+		writeError(w, h.logger, "", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(rAssignment); err != nil {
-		h.logger.Error("failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := h.signAssignment(projectName, assignment); err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to sign relay assignment",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to sign relay assignment", http.StatusInternalServerError)
 		return
 	}
+
+	writeJSON(w, h.logger, http.StatusOK, assignment)
 }
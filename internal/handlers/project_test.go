@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/requestid"
+)
+
+func TestWriteReplay(t *testing.T) {
+	saved := &models.CapturedResponse{
+		StatusCode: http.StatusCreated,
+		Headers: http.Header{
+			"Content-Type":      {"application/json"},
+			"Set-Cookie":        {"a=1", "b=2"},
+			requestid.Header:    {"01ORIGINAL"},
+			"Connection":        {"keep-alive, X-Hop"},
+			"X-Hop":             {"per-connection"},
+			"Keep-Alive":        {"timeout=5"},
+			"Transfer-Encoding": {"chunked"},
+		},
+		Body: []byte(`{"ok":true}`),
+	}
+
+	w := httptest.NewRecorder()
+	// The middleware has already given the duplicate its own ID.
+	w.Header().Set(requestid.Header, "01DUPLICATE")
+
+	if err := writeReplay(w, saved, "01ORIGINAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := w.Result().Header
+	if ids := got.Values(requestid.Header); !slices.Equal(ids, []string{"01DUPLICATE"}) {
+		t.Errorf("request IDs = %q, want only the duplicate's", ids)
+	}
+	if got.Get("X-Whook-Duplicate-Of") != "01ORIGINAL" {
+		t.Errorf("X-Whook-Duplicate-Of = %q", got.Get("X-Whook-Duplicate-Of"))
+	}
+	for _, name := range []string{"Connection", "X-Hop", "Keep-Alive", "Transfer-Encoding"} {
+		if v := got.Values(name); len(v) > 0 {
+			t.Errorf("replayed hop-by-hop header %s: %q", name, v)
+		}
+	}
+	if v := got.Values("Set-Cookie"); !slices.Equal(v, []string{"a=1", "b=2"}) {
+		t.Errorf("Set-Cookie = %q, want both values", v)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != `{"ok":true}` {
+		t.Errorf("replayed %d %q", w.Code, w.Body.String())
+	}
+
+	// The saved response itself is left as it was.
+	if saved.Headers.Get(requestid.Header) != "01ORIGINAL" || saved.Headers.Get("X-Hop") == "" {
+		t.Error("writeReplay modified the saved response")
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// maxCapturedBody caps how much of a relay response is kept for replaying to
+// duplicate deliveries. Larger responses are passed through but not replayed.
+const maxCapturedBody = 1 << 20
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	statusCode int
+	body       []byte
+	truncated  bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if rr.statusCode == 0 {
+		rr.statusCode = statusCode
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.statusCode == 0 {
+		rr.statusCode = http.StatusOK
+	}
+
+	if !rr.truncated {
+		if len(rr.body)+len(b) > maxCapturedBody {
+			rr.truncated = true
+			rr.body = nil
+		} else {
+			rr.body = append(rr.body, b...)
+		}
+	}
+
+	return rr.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func (rr *responseRecorder) Flush() {
+	http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Captured returns the recorded response, or nil if it was too large to keep.
+func (rr *responseRecorder) Captured() *models.CapturedResponse {
+	if rr.truncated || rr.statusCode == 0 {
+		return nil
+	}
+
+	return &models.CapturedResponse{
+		StatusCode: rr.statusCode,
+		Headers:    rr.Header().Clone(),
+		Body:       rr.body,
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/storage"
+)
+
+type RequestsHandler struct {
+	cfg     *config.Config
+	storage *storage.RequestStorage
+	logger  *slog.Logger
+}
+
+func NewRequestsHandler(cfg *config.Config, s *storage.RequestStorage, logger *slog.Logger) *RequestsHandler {
+	return &RequestsHandler{
+		cfg:     cfg,
+		storage: s,
+		logger:  logger.With("component", "requests_handler"),
+	}
+}
+
+func (h *RequestsHandler) HandleListRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.RequestFilter{
+		ProjectName: r.PathValue("project"),
+		Provider:    query.Get("provider"),
+		EventType:   query.Get("event"),
+		DeliveryID:  query.Get("delivery_id"),
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if raw := query.Get("before"); raw != "" {
+		before, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			http.Error(w, "before must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+
+	reqs, err := h.storage.ListRequests(r.Context(), filter)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to list requests",
+			"project", filter.ProjectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to list requests", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reqs); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *RequestsHandler) HandleGetRequest(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	id := r.PathValue("id")
+
+	req, err := h.storage.GetRequest(r.Context(), projectName, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrRequestNotFound) {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "unable to fetch request",
+			"project", projectName,
+			"request_id", id,
+			"error", err,
+		)
+		http.Error(w, "Unable to fetch request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
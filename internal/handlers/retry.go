@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/metrics"
+)
+
+var deliveryAttempts = metrics.NewCounterVec("whook_delivery_attempts_total",
+	"Attempts to deliver a webhook to a relay.", "mode", "outcome")
+
+// RetryPolicy decides whether a failed delivery is worth repeating and how
+// long to wait before doing so.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	statuses map[int]bool
+}
+
+func NewRetryPolicy(cfg *config.Config) *RetryPolicy {
+	statuses := make(map[int]bool, len(cfg.RelayRetryStatuses))
+	for _, code := range cfg.RelayRetryStatuses {
+		statuses[code] = true
+	}
+
+	return &RetryPolicy{
+		MaxAttempts: cfg.RelayRetryMaxAttempts,
+		BaseDelay:   cfg.RelayRetryBaseDelay,
+		MaxDelay:    cfg.RelayRetryMaxDelay,
+		statuses:    statuses,
+	}
+}
+
+// Retryable reports whether an attempt failed in a way another attempt might
+// fix. Errors caused by the caller giving up are not retried.
+func (p *RetryPolicy) Retryable(ctx context.Context, statusCode int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return p.statuses[statusCode]
+}
+
+// Backoff returns the delay before the given attempt, doubling from BaseDelay
+// up to maxDelay with jitter so retries from many senders spread out.
+func (p *RetryPolicy) Backoff(attempt int, maxDelay time.Duration) time.Duration {
+	delay := p.BaseDelay
+	for i := 2; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+var errResponseHeaderTimeout = errors.New("timeout awaiting relay response headers")
+
+// headerTimeoutTransport limits how long a relay may take to start its
+// response without limiting how long the response may then stream for. The
+// timeout is per request, unlike http.Transport's, so projects can override
+// it on a shared transport. A timeout of zero or less means no limit.
+type headerTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, errResponseHeaderTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// attemptFunc is told about every attempt a retryTransport makes.
+type attemptFunc func(attempt int, started time.Time, resp *http.Response, err error)
+
+// retryTransport repeats a relay request until it succeeds, the policy gives
+// up, or the next attempt would start after the deadline. The request must
+// have GetBody set so the body can be replayed.
+type retryTransport struct {
+	base      http.RoundTripper
+	policy    *RetryPolicy
+	deadline  time.Time
+	onAttempt attemptFunc
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	deadline := t.deadline
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	for attempt := 1; ; attempt++ {
+		out := req
+		if attempt > 1 {
+			out = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				out.Body = body
+			}
+		}
+
+		started := time.Now()
+		resp, err := t.base.RoundTrip(out)
+		t.onAttempt(attempt, started, resp, err)
+
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		if attempt >= t.policy.MaxAttempts || !t.policy.Retryable(ctx, statusCode, err) {
+			return resp, err
+		}
+
+		delay := t.policy.Backoff(attempt+1, t.policy.MaxDelay)
+		if time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/dedup"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/signature"
+)
+
+type SettingsHandler struct {
+	cfg      *config.Config
+	settings *projects.SettingsStore
+	logger   *slog.Logger
+}
+
+func NewSettingsHandler(cfg *config.Config, ss *projects.SettingsStore, logger *slog.Logger) *SettingsHandler {
+	return &SettingsHandler{
+		cfg:      cfg,
+		settings: ss,
+		logger:   logger.With("component", "settings_handler"),
+	}
+}
+
+func (h *SettingsHandler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+
+	settings, err := h.settings.Get(r.Context(), projectName)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to fetch project settings",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to fetch project settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(redactSettings(settings)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *SettingsHandler) HandlePutSettings(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+
+	var settings models.ProjectSettings
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&settings); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if msg := validateSettings(&settings, h.cfg.RelayMaxTimeoutOverride); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.settings.Put(r.Context(), projectName, &settings); err != nil {
+		h.logger.ErrorContext(r.Context(), "unable to save project settings",
+			"project", projectName,
+			"error", err,
+		)
+		http.Error(w, "Unable to save project settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(redactSettings(&settings)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// validateSettings returns a message describing the first problem with the
+// settings, or an empty string if they are valid.
+func validateSettings(settings *models.ProjectSettings, maxTimeout time.Duration) string {
+	if d := settings.Dedup; d != nil {
+		if (d.Header == "") == (d.JSONPath == "") {
+			return "dedup requires exactly one of header or json_path"
+		}
+		if d.WindowSeconds < 0 || int64(d.WindowSeconds) > int64(dedup.MaxWindow/time.Second) {
+			return "dedup window_seconds must be between 0 and " + strconv.Itoa(int(dedup.MaxWindow/time.Second))
+		}
+	}
+
+	if v := settings.Verification; v != nil {
+		if v.Mode != "" && v.Mode != models.VerificationModeReject && v.Mode != models.VerificationModeTag {
+			return "verification mode must be reject or tag"
+		}
+		// Checked in seconds, since a large value overflows the Duration.
+		if v.ToleranceSeconds < 0 || int64(v.ToleranceSeconds) > int64(signature.MaxTolerance/time.Second) {
+			return "verification tolerance_seconds must be between 0 and " + strconv.Itoa(int(signature.MaxTolerance/time.Second))
+		}
+		if _, err := signature.New(v); err != nil {
+			return "invalid verification settings: " + err.Error()
+		}
+	}
+
+	if t := settings.Timeouts; t != nil {
+		if t.ResponseHeaderSeconds < 0 || t.StreamSeconds < 0 {
+			return "timeouts cannot be negative"
+		}
+		maxSeconds := int64(maxTimeout / time.Second)
+		if int64(t.ResponseHeaderSeconds) > maxSeconds || int64(t.StreamSeconds) > maxSeconds {
+			return "timeouts cannot exceed " + maxTimeout.String()
+		}
+	}
+
+	return ""
+}
+
+// redactSettings returns a copy of the settings that is safe to send back to
+// clients.
+func redactSettings(settings *models.ProjectSettings) *models.ProjectSettings {
+	redacted := *settings
+	if settings.Verification != nil {
+		v := *settings.Verification
+		v.Secret = ""
+		redacted.Verification = &v
+	}
+	return &redacted
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+func TestValidateSettings(t *testing.T) {
+	verification := func(tolerance int) *models.ProjectSettings {
+		return &models.ProjectSettings{Verification: &models.VerificationSettings{
+			Scheme:           "github",
+			Secret:           "s",
+			ToleranceSeconds: tolerance,
+		}}
+	}
+
+	window := func(seconds int) *models.ProjectSettings {
+		return &models.ProjectSettings{Dedup: &models.DedupRule{
+			Header:        "Idempotency-Key",
+			WindowSeconds: seconds,
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		settings *models.ProjectSettings
+		valid    bool
+	}{
+		{"empty", &models.ProjectSettings{}, true},
+		{"default tolerance", verification(0), true},
+		{"day tolerance", verification(86400), true},
+		{"negative tolerance", verification(-1), false},
+		{"tolerance over a day", verification(86401), false},
+		{"tolerance overflowing a duration", verification(math.MaxInt64/int(time.Second) + 1), false},
+		{"largest int tolerance", verification(math.MaxInt), false},
+		{"default window", window(0), true},
+		{"thirty day window", window(30 * 86400), true},
+		{"negative window", window(-1), false},
+		{"window over thirty days", window(30*86400 + 1), false},
+		{"window overflowing a duration", window(math.MaxInt64/int(time.Second) + 1), false},
+		{"dedup without a key", &models.ProjectSettings{Dedup: &models.DedupRule{}}, false},
+		{"timeout at the limit", &models.ProjectSettings{Timeouts: &models.TimeoutSettings{StreamSeconds: 3600}}, true},
+		{"timeout over the limit", &models.ProjectSettings{Timeouts: &models.TimeoutSettings{StreamSeconds: 3601}}, false},
+		{"timeout overflowing a duration", &models.ProjectSettings{Timeouts: &models.TimeoutSettings{ResponseHeaderSeconds: math.MaxInt64/int(time.Second) + 1}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := validateSettings(tt.settings, time.Hour)
+			if (msg == "") != tt.valid {
+				t.Fatalf("validateSettings = %q, want valid %v", msg, tt.valid)
+			}
+		})
+	}
+}
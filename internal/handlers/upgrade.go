@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whookdev/conductor/internal/metrics"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+var upgradedConnections = metrics.NewGauge("whook_upgraded_connections",
+	"Upgraded connections, such as WebSockets, currently proxied to relays.")
+
+// upgradeType returns the protocol a request asks to switch to, or "" if it
+// isn't an upgrade request.
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// IsUpgrade reports whether r asks to switch protocols, as a WebSocket
+// handshake does.
+func IsUpgrade(r *http.Request) bool {
+	return upgradeType(r.Header) != ""
+}
+
+// Upgrade proxies a protocol upgrade to the relay and, once the relay has
+// switched protocols, copies bytes in both directions until either side
+// closes or the connection has been idle for RelayUpgradeIdleTimeout. The
+// server's read and write timeouts are lifted for the hijacked connection.
+func (f *Forwarder) Upgrade(w http.ResponseWriter, r *http.Request, d Delivery) {
+	logger := f.logger.With("project", d.Request.ProjectName, "relay_id", d.Relay.ID)
+	reqUpType := upgradeType(r.Header)
+
+	started := time.Now()
+	resp, err := f.openUpgrade(r, d, reqUpType)
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	f.recordAttempt(r.Context(), d, models.DeliveryModeSync, 1, started, statusCode, err)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to forward upgrade request", "error", err)
+		writeError(w, logger, d.Request.ID, err)
+		return
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The relay declined; pass its answer back as an ordinary response.
+		defer resp.Body.Close()
+		removeHopHeaders(resp.Header)
+		copyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		logger.ErrorContext(r.Context(), "relay switched protocols without a writable body")
+		writeError(w, logger, d.Request.ID, errors.New("switching protocols response with non-writable body"))
+		return
+	}
+	defer backConn.Close()
+
+	if resUpType := upgradeType(resp.Header); !strings.EqualFold(resUpType, reqUpType) {
+		logger.ErrorContext(r.Context(), "relay switched to an unexpected protocol",
+			"requested", reqUpType,
+			"switched", resUpType)
+		writeError(w, logger, d.Request.ID, newRelayError(d.Relay.ID,
+			fmt.Errorf("relay switched to %q when %q was requested", resUpType, reqUpType)))
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "unable to take over connection for upgrade", "error", err)
+		writeError(w, logger, d.Request.ID, err)
+		return
+	}
+	defer conn.Close()
+
+	// Deadlines set by the server for ordinary requests stay on a hijacked
+	// connection and would cut the stream off.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		logger.ErrorContext(r.Context(), "unable to clear connection deadlines", "error", err)
+		return
+	}
+
+	copyHeader(w.Header(), resp.Header)
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		logger.ErrorContext(r.Context(), "failed to write upgrade response", "error", err)
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		logger.ErrorContext(r.Context(), "failed to flush upgrade response", "error", err)
+		return
+	}
+
+	upgradedConnections.Add(1)
+	defer upgradedConnections.Add(-1)
+
+	logger.InfoContext(r.Context(), "proxying upgraded connection", "protocol", reqUpType)
+	sent, received := f.pipeUpgrade(r.Context(), conn, brw.Reader, backConn)
+	logger.InfoContext(r.Context(), "upgraded connection closed",
+		"protocol", reqUpType,
+		"duration", time.Since(started),
+		"bytes_to_relay", sent,
+		"bytes_from_relay", received)
+}
+
+func (f *Forwarder) openUpgrade(r *http.Request, d Delivery, upType string) (*http.Response, error) {
+	target, err := f.relayURL(d.Relay)
+	if err != nil {
+		return nil, newRelayError(d.Relay.ID, fmt.Errorf("invalid relay url: %w", err))
+	}
+
+	transport, err := f.transportFor(d.Relay, target, true)
+	if err != nil {
+		return nil, newRelayError(d.Relay.ID, fmt.Errorf("unable to secure relay connection: %w", err))
+	}
+
+	// The relay only has the handshake timeout to answer; after that the
+	// connection belongs to the stream and request cancellation must not
+	// tear it down.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), d.headerTimeout(f.cfg))
+	out := r.Clone(ctx)
+	out.RequestURI = ""
+	out.Close = false
+	removeHopHeaders(out.Header)
+
+	f.rewrite(&httputil.ProxyRequest{In: r, Out: out}, target, d)
+	out.Header.Set("Connection", "Upgrade")
+	out.Header.Set("Upgrade", upType)
+
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		cancel()
+		return nil, newRelayError(d.Relay.ID, err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = cancelOnClose{resp.Body, cancel}
+		return resp, nil
+	}
+
+	// A switched connection is no longer tied to the request context, but
+	// the timer still has to be released.
+	cancel()
+	return resp, nil
+}
+
+// pipeUpgrade copies between the client and relay until one side is done or
+// nothing has moved for the idle timeout, returning the bytes sent each way.
+func (f *Forwarder) pipeUpgrade(ctx context.Context, client io.ReadWriteCloser, clientReader io.Reader, relay io.ReadWriteCloser) (int64, int64) {
+	var (
+		lastActivity atomic.Int64
+		sent         atomic.Int64
+		received     atomic.Int64
+		closeOnce    sync.Once
+		done         = make(chan struct{})
+	)
+	lastActivity.Store(time.Now().UnixNano())
+
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			close(done)
+			client.Close()
+			relay.Close()
+		})
+	}
+
+	pipe := func(dst io.Writer, src io.Reader, counter *atomic.Int64) {
+		defer closeBoth()
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastActivity.Store(time.Now().UnixNano())
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+				counter.Add(int64(n))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	go pipe(relay, clientReader, &sent)
+	go pipe(client, relay, &received)
+
+	idle := f.cfg.RelayUpgradeIdleTimeout
+	ticker := time.NewTicker(max(idle/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return sent.Load(), received.Load()
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, lastActivity.Load())) > idle {
+				f.logger.InfoContext(ctx, "closing idle upgraded connection", "idle_timeout", idle)
+				closeBoth()
+			}
+		}
+	}
+}
+
+// cancelOnClose releases a request context once the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
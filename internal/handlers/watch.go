@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whookdev/conductor/internal/conductor"
+	"github.com/whookdev/conductor/internal/routing"
+)
+
+const (
+	// watchKeepalive keeps idle event streams from being closed by proxies.
+	watchKeepalive = 15 * time.Second
+	// watchDeadlineSlack lets a watch finish writing its last answer after
+	// its timeout fires.
+	watchDeadlineSlack = 10 * time.Second
+)
+
+// HandleWatchRelay tells a CLI or relay when a project's assignment changes.
+// Clients that accept text/event-stream get a stream of events: assignment
+// whenever the project is assigned or moved, relay_gone once its relay has
+// missed heartbeats long enough to be passed over, which the conductor's
+// cleanup routine announces within a few seconds, and unassigned when it
+// loses its relay altogether. Other clients long-poll: given the generation
+// they hold, the request returns the assignment as soon as it differs, 404
+// if the project is unassigned, or 204 if nothing changed within the poll
+// timeout. A long poll keeps waiting while the relay is gone, since the
+// project is about to be moved. Generation 0 waits for a first assignment.
+func (h *ProjectHandler) HandleWatchRelay(w http.ResponseWriter, r *http.Request) {
+	projectName := r.PathValue("project")
+	if err := routing.ValidateProjectName(projectName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Watch before the first read so a change between the two isn't missed.
+	changes, stop := h.conductor.WatchAssignment(projectName)
+	defer stop()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamAssignment(w, r, projectName, changes)
+		return
+	}
+	h.longPollAssignment(w, r, projectName, changes)
+}
+
+func (h *ProjectHandler) longPollAssignment(w http.ResponseWriter, r *http.Request, projectName string, changes <-chan struct{}) {
+	var (
+		known    int64
+		hasKnown bool
+	)
+	if raw := r.URL.Query().Get("generation"); raw != "" {
+		var err error
+		if known, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			http.Error(w, "generation must be an integer", http.StatusBadRequest)
+			return
+		}
+		hasKnown = true
+	}
+
+	timeout := h.cfg.AssignmentLongPollTimeout
+	h.extendDeadline(w, r, timeout)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		assignment, err := h.conductor.CurrentAssignment(projectName)
+		switch {
+		case err == nil && (!hasKnown || assignment.Generation != known):
+			if err := h.signAssignment(projectName, assignment); err != nil {
+				h.logger.ErrorContext(r.Context(), "unable to sign relay assignment",
+					"project", projectName,
+					"error", err,
+				)
+				http.Error(w, "Unable to sign relay assignment", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, h.logger, http.StatusOK, assignment)
+			return
+		case errors.Is(err, conductor.ErrProjectNotAssigned) && (!hasKnown || known != 0):
+			http.Error(w, "Project has no relay assigned", http.StatusNotFound)
+			return
+		case err == nil, errors.Is(err, conductor.ErrProjectNotAssigned), errors.Is(err, conductor.ErrRelayGone):
+			// Unchanged, waiting for a first assignment, or waiting to be
+			// moved off a dead relay.
+		default:
+			h.logger.ErrorContext(r.Context(), "unable to look up relay assignment",
+				"project", projectName,
+				"error", err,
+			)
+			writeError(w, h.logger, "", err)
+			return
+		}
+
+		select {
+		case <-changes:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *ProjectHandler) streamAssignment(w http.ResponseWriter, r *http.Request, projectName string, changes <-chan struct{}) {
+	timeout := h.cfg.AssignmentStreamTimeout
+	h.extendDeadline(w, r, timeout)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) bool {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write("retry: 2000\n\n") {
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "watching relay assignment", "project", projectName)
+
+	keepalive := time.NewTicker(watchKeepalive)
+	defer keepalive.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	last := ""
+	for {
+		event, id, data, ok := h.assignmentEvent(r, projectName)
+		// Tokens differ on every read, so only the event and generation
+		// decide whether anything changed.
+		if key := event + ":" + id; ok && key != last {
+			if id != "" && !write("id: %s\n", id) {
+				return
+			}
+			if !write("event: %s\ndata: %s\n\n", event, data) {
+				return
+			}
+			last = key
+		}
+
+		select {
+		case <-changes:
+		case <-keepalive.C:
+			if !write(": keepalive\n\n") {
+				return
+			}
+		case <-deadline.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// assignmentEvent reads a project's assignment as a server-sent event. ok is
+// false if it couldn't be read; the stream then waits for the next change.
+func (h *ProjectHandler) assignmentEvent(r *http.Request, projectName string) (event, id string, data []byte, ok bool) {
+	assignment, err := h.conductor.CurrentAssignment(projectName)
+
+	var payload any
+	switch {
+	case err == nil:
+		if err := h.signAssignment(projectName, assignment); err != nil {
+			h.logger.ErrorContext(r.Context(), "unable to sign relay assignment",
+				"project", projectName,
+				"error", err,
+			)
+			return "", "", nil, false
+		}
+		event, id, payload = "assignment", strconv.FormatInt(assignment.Generation, 10), assignment
+	case errors.Is(err, conductor.ErrProjectNotAssigned):
+		event, payload = "unassigned", map[string]string{"project": projectName}
+	case errors.Is(err, conductor.ErrRelayGone):
+		event, payload = "relay_gone", map[string]string{"project": projectName}
+	default:
+		h.logger.ErrorContext(r.Context(), "unable to look up relay assignment",
+			"project", projectName,
+			"error", err,
+		)
+		return "", "", nil, false
+	}
+
+	data, err = json.Marshal(payload)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode assignment event", "error", err)
+		return "", "", nil, false
+	}
+	return event, id, data, true
+}
+
+// extendDeadline lifts the listener's write timeout, which is sized for
+// short requests, for as long as the watch may run.
+func (h *ProjectHandler) extendDeadline(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(timeout + watchDeadlineSlack)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.WarnContext(r.Context(), "unable to extend write deadline", "error", err)
+	}
+}
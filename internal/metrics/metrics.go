@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector is anything that can write itself out in the Prometheus text
+// exposition format.
+type collector interface {
+	write(sb *strings.Builder)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+var defaultRegistry = &registry{collectors: make(map[string]collector)}
+
+func (r *registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.collectors[name]; exists {
+		panic(fmt.Sprintf("metric %s already registered", name))
+	}
+	r.collectors[name] = c
+}
+
+type Counter struct {
+	name   string
+	help   string
+	labels string
+	value  atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	defaultRegistry.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(sb *strings.Builder) {
+	writeHeader(sb, c.name, c.help, "counter")
+	fmt.Fprintf(sb, "%s%s %d\n", c.name, c.labels, c.Value())
+}
+
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]*Counter
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]*Counter),
+	}
+	defaultRegistry.register(name, cv)
+	return cv
+}
+
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	if len(values) != len(cv.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d",
+			cv.name, len(cv.labelNames), len(values)))
+	}
+
+	labels := formatLabels(cv.labelNames, values)
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	c, ok := cv.children[labels]
+	if !ok {
+		c = &Counter{name: cv.name, labels: labels}
+		cv.children[labels] = c
+	}
+	return c
+}
+
+func (cv *CounterVec) write(sb *strings.Builder) {
+	writeHeader(sb, cv.name, cv.help, "counter")
+
+	cv.mu.Lock()
+	keys := make([]string, 0, len(cv.children))
+	for k := range cv.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %d\n", cv.name, k, cv.children[k].Value())
+	}
+	cv.mu.Unlock()
+}
+
+type Gauge struct {
+	name   string
+	help   string
+	labels string
+	bits   atomic.Uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	defaultRegistry.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(sb *strings.Builder) {
+	writeHeader(sb, g.name, g.help, "gauge")
+	fmt.Fprintf(sb, "%s%s %g\n", g.name, g.labels, g.Value())
+}
+
+type GaugeVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]*Gauge
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	gv := &GaugeVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]*Gauge),
+	}
+	defaultRegistry.register(name, gv)
+	return gv
+}
+
+func (gv *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	if len(values) != len(gv.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d",
+			gv.name, len(gv.labelNames), len(values)))
+	}
+
+	labels := formatLabels(gv.labelNames, values)
+
+	gv.mu.Lock()
+	defer gv.mu.Unlock()
+
+	g, ok := gv.children[labels]
+	if !ok {
+		g = &Gauge{name: gv.name, labels: labels}
+		gv.children[labels] = g
+	}
+	return g
+}
+
+// Reset removes every child, for gauges that are rebuilt from a snapshot and
+// would otherwise keep reporting label values that have gone away.
+func (gv *GaugeVec) Reset() {
+	gv.mu.Lock()
+	defer gv.mu.Unlock()
+
+	gv.children = make(map[string]*Gauge)
+}
+
+func (gv *GaugeVec) write(sb *strings.Builder) {
+	writeHeader(sb, gv.name, gv.help, "gauge")
+
+	gv.mu.Lock()
+	keys := make([]string, 0, len(gv.children))
+	for k := range gv.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %g\n", gv.name, k, gv.children[k].Value())
+	}
+	gv.mu.Unlock()
+}
+
+// GaugeFunc reports the value returned by fn at scrape time, which suits
+// values that are already tracked elsewhere such as the length of a channel.
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	defaultRegistry.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(sb *strings.Builder) {
+	writeHeader(sb, g.name, g.help, "gauge")
+	fmt.Fprintf(sb, "%s %g\n", g.name, g.fn())
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultRegistry.mu.Lock()
+		names := make([]string, 0, len(defaultRegistry.collectors))
+		for name := range defaultRegistry.collectors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var sb strings.Builder
+		for _, name := range names {
+			defaultRegistry.collectors[name].write(&sb)
+		}
+		defaultRegistry.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(sb.String()))
+	})
+}
+
+func writeHeader(sb *strings.Builder, name, help, kind string) {
+	if help != "" {
+		fmt.Fprintf(sb, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(sb, "# TYPE %s %s\n", name, kind)
+}
+
+// labelEscaper escapes label values for the exposition format. Building a
+// Replacer is costly, and label sets are formatted on every increment.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// APIKey is a credential scoped to one project. Only a hash of its secret is
+// stored; the full key is shown once, when it is created or rotated.
+type APIKey struct {
+	ID          string     `json:"id"`
+	ProjectName string     `json:"project_name"`
+	Name        string     `json:"name"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	// ExpiresAt is set on a key that has been rotated, which keeps working
+	// until then so clients can switch over.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key can still authenticate at t.
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
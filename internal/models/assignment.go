@@ -1,6 +1,38 @@
 package models
 
+import "time"
+
 type RelayAssignment struct {
 	RelayID    string `json:"id"`
 	RelayWSURL string `json:"ws_url"`
+	Generation int64  `json:"generation"`
+	// Token is presented by the CLI when it connects to the relay, which
+	// verifies it against the conductor's published keys. It is only set
+	// when assignment signing keys are configured.
+	Token          string     `json:"token,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+}
+
+// Why a project was given a new relay.
+const (
+	// AssignmentReasonNew is a project's first assignment, or its first
+	// since it lost its relay.
+	AssignmentReasonNew = "new"
+	// AssignmentReasonRelayGone moved a project off a relay that stopped
+	// heartbeating.
+	AssignmentReasonRelayGone = "relay_gone"
+	// AssignmentReasonForced is a client asking for a new relay.
+	AssignmentReasonForced = "forced"
+	// AssignmentReasonPreferred moved a project to the relay a client asked
+	// for.
+	AssignmentReasonPreferred = "preferred_relay"
+)
+
+// AssignmentRecord is one entry in a project's assignment history.
+type AssignmentRecord struct {
+	Generation      int64     `json:"generation"`
+	RelayID         string    `json:"relay_id"`
+	PreviousRelayID string    `json:"previous_relay_id,omitempty"`
+	Reason          string    `json:"reason"`
+	AssignedAt      time.Time `json:"assigned_at"`
 }
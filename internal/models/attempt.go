@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	DeliveryModeSync  = "sync"
+	DeliveryModeAsync = "async"
+)
+
+// DeliveryAttempt records one try at forwarding a stored request to a relay.
+// StatusCode is zero when the relay couldn't be reached.
+type DeliveryAttempt struct {
+	RequestID   string    `json:"request_id"`
+	ProjectName string    `json:"project_name"`
+	Attempt     int       `json:"attempt"`
+	Mode        string    `json:"mode"`
+	RelayID     string    `json:"relay_id,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Reasons a webhook ends up in the dead-letter queue.
+const (
+	DeadLetterRetriesExhausted = "retries_exhausted"
+	DeadLetterExpired          = "expired"
+	DeadLetterNoRelay          = "no_relay"
+	DeadLetterInterrupted      = "interrupted"
+	// DeadLetterMisconfigured means the relay couldn't be contacted at all,
+	// such as an unparseable URL or TLS settings that can't be met, so no
+	// attempt was made.
+	DeadLetterMisconfigured = "relay_misconfigured"
+)
+
+// DeadLetter marks a stored request that never reached a relay. The request
+// itself stays in the request log; Request is only populated when a single
+// dead letter is looked up.
+type DeadLetter struct {
+	RequestID   string         `json:"request_id"`
+	ProjectName string         `json:"project_name"`
+	Reason      string         `json:"reason"`
+	Attempts    int            `json:"attempts"`
+	LastStatus  int            `json:"last_status,omitempty"`
+	LastError   string         `json:"last_error,omitempty"`
+	DeadAt      time.Time      `json:"dead_at"`
+	Request     *StoredRequest `json:"request,omitempty"`
+}
+
+// DeadLetterFilter narrows a listing of dead letters.
+type DeadLetterFilter struct {
+	ProjectName string
+	Before      time.Time
+	Limit       int
+}
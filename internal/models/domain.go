@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Ways a project can prove it controls a custom domain. Only DNS is
+// offered: anything served over HTTP by a domain pointed at the conductor
+// would be served by the conductor, and so prove nothing about who owns it.
+const (
+	// DomainChallengeDNS looks for the token in a TXT record at
+	// _whook-challenge.<hostname>.
+	DomainChallengeDNS = "dns"
+)
+
+// CustomDomain is a hostname a project receives webhooks on in addition to
+// its subdomain of the base domain. Only verified domains are routed.
+type CustomDomain struct {
+	Hostname    string     `json:"hostname"`
+	ProjectName string     `json:"project_name"`
+	Token       string     `json:"token"`
+	Verified    bool       `json:"verified"`
+	CreatedAt   time.Time  `json:"created_at"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	// ClaimExpiresAt is when an unverified claim lapses and the domain can
+	// be claimed by another project.
+	ClaimExpiresAt *time.Time `json:"claim_expires_at,omitempty"`
+}
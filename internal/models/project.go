@@ -0,0 +1,77 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// ProjectSettings holds the per-project behaviour configured through the API.
+// Every field is optional; a zero value means the feature is disabled.
+type ProjectSettings struct {
+	Dedup        *DedupRule            `json:"dedup,omitempty"`
+	Verification *VerificationSettings `json:"verification,omitempty"`
+	Timeouts     *TimeoutSettings      `json:"timeouts,omitempty"`
+}
+
+// DedupRule names where a provider carries its idempotency key. Exactly one
+// of Header or JSONPath should be set.
+type DedupRule struct {
+	Header         string `json:"header,omitempty"`
+	JSONPath       string `json:"json_path,omitempty"`
+	WindowSeconds  int    `json:"window_seconds,omitempty"`
+	ReplayResponse bool   `json:"replay_response,omitempty"`
+}
+
+func (d *DedupRule) Window(fallback time.Duration) time.Duration {
+	return seconds(d.WindowSeconds, fallback)
+}
+
+// TimeoutSettings overrides how long the conductor waits on a project's relay,
+// for local endpoints that are slow to answer or stream their responses.
+type TimeoutSettings struct {
+	ResponseHeaderSeconds int `json:"response_header_seconds,omitempty"`
+	StreamSeconds         int `json:"stream_seconds,omitempty"`
+}
+
+func (t *TimeoutSettings) ResponseHeader(fallback time.Duration) time.Duration {
+	return seconds(t.ResponseHeaderSeconds, fallback)
+}
+
+func (t *TimeoutSettings) Stream(fallback time.Duration) time.Duration {
+	return seconds(t.StreamSeconds, fallback)
+}
+
+const (
+	VerificationModeReject = "reject"
+	VerificationModeTag    = "tag"
+)
+
+// VerificationSettings configures how inbound webhook signatures are checked.
+// Header, Algorithm, Encoding and Prefix only apply to the generic hmac scheme.
+type VerificationSettings struct {
+	Scheme           string `json:"scheme"`
+	Secret           string `json:"secret,omitempty"`
+	Mode             string `json:"mode,omitempty"`
+	ToleranceSeconds int    `json:"tolerance_seconds,omitempty"`
+	Header           string `json:"header,omitempty"`
+	Algorithm        string `json:"algorithm,omitempty"`
+	Encoding         string `json:"encoding,omitempty"`
+	Prefix           string `json:"prefix,omitempty"`
+}
+
+func (v *VerificationSettings) Tolerance(fallback time.Duration) time.Duration {
+	return seconds(v.ToleranceSeconds, fallback)
+}
+
+func (v *VerificationSettings) Rejects() bool {
+	return v.Mode == "" || v.Mode == VerificationModeReject
+}
+
+// seconds converts a count of seconds from the API into a duration. Zero,
+// negative and values too large for a Duration all mean unset.
+func seconds(n int, fallback time.Duration) time.Duration {
+	if n <= 0 || int64(n) > math.MaxInt64/int64(time.Second) {
+		return fallback
+	}
+	return time.Duration(n) * time.Second
+}
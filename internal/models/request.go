@@ -1,12 +1,46 @@
 package models
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 type StoredRequest struct {
-	Method      string            `json:"method"`
-	Path        string            `json:"path"`
-	Headers     map[string]string `json:"headers"`
-	Body        []byte            `json:"body"`
-	ProjectName string            `json:"project_name"`
-	ReceivedAt  time.Time         `json:"received_at"`
+	ID              string            `json:"id"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	Query           string            `json:"query,omitempty"`
+	Headers         map[string]string `json:"headers"`
+	Body            []byte            `json:"body"`
+	ProjectName     string            `json:"project_name"`
+	ReceivedAt      time.Time         `json:"received_at"`
+	IdempotencyKey  string            `json:"idempotency_key,omitempty"`
+	DuplicateOf     string            `json:"duplicate_of,omitempty"`
+	Provider        string            `json:"provider,omitempty"`
+	EventType       string            `json:"event_type,omitempty"`
+	DeliveryID      string            `json:"delivery_id,omitempty"`
+	SignatureStatus string            `json:"signature_status,omitempty"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+
+	// RequestURI, Host, RemoteAddr and HeaderValues keep what a redrive
+	// needs to rebuild the request exactly as it arrived. Requests stored
+	// before they were recorded fall back to Path, Query and Headers.
+	RequestURI   string      `json:"request_uri,omitempty"`
+	Host         string      `json:"host,omitempty"`
+	RemoteAddr   string      `json:"-"`
+	HeaderValues http.Header `json:"-"`
+
+	// Attempts is only populated when a single request is looked up.
+	Attempts []*DeliveryAttempt `json:"attempts,omitempty"`
+}
+
+// RequestFilter narrows a listing of stored requests. Empty fields match
+// everything.
+type RequestFilter struct {
+	ProjectName string
+	Provider    string
+	EventType   string
+	DeliveryID  string
+	Before      time.Time
+	Limit       int
 }
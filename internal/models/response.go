@@ -0,0 +1,11 @@
+package models
+
+import "net/http"
+
+// CapturedResponse is a relay response kept so duplicate deliveries can be
+// answered without forwarding them again.
+type CapturedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+}
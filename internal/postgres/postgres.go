@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/whookdev/conductor/internal/config"
+)
+
+type PostgresServer struct {
+	cfg    *config.Config
+	Pool   *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func New(cfg *config.Config, logger *slog.Logger) (*PostgresServer, error) {
+	logger = logger.With("component", "postgres")
+
+	ps := &PostgresServer{
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	return ps, nil
+}
+
+func (ps *PostgresServer) Start(ctx context.Context) error {
+	pool, err := pgxpool.New(ctx, ps.cfg.PostgresURL)
+	if err != nil {
+		ps.logger.Error("failed to create postgres pool", "error", err)
+		return err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		ps.logger.Error("failed to connect to postgres", "error", err)
+		return err
+	}
+
+	ps.Pool = pool
+
+	ps.logger.Info("postgres connection established")
+	return nil
+}
+
+func (ps *PostgresServer) Stop() error {
+	if ps.Pool != nil {
+		ps.Pool.Close()
+		ps.logger.Info("postgres connection closed successfully")
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package projects
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKey covers every way a presented key can fail, so callers
+	// can't tell a wrong secret from a revoked or unknown key.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+)
+
+// APIKeyPrefix starts every key, so leaked keys are easy to scan for. The
+// key ID follows it, then the secret: whk_<id>_<secret>.
+const APIKeyPrefix = "whk_"
+
+const (
+	apiKeyIDBytes     = 6
+	apiKeySecretBytes = 32
+	// lastUsedResolution limits last_used_at to one write per key per
+	// interval, however busy the key is.
+	lastUsedResolution = time.Minute
+)
+
+const (
+	insertAPIKeySQL = `
+INSERT INTO api_keys (id, project_name, name, secret_hash, created_at)
+VALUES ($1, $2, $3, $4, $5)`
+
+	selectAPIKeyColumns = `
+SELECT id, project_name, name, created_at, last_used_at, expires_at, revoked_at
+FROM api_keys`
+
+	selectAPIKeySecretSQL = `
+SELECT id, project_name, name, created_at, last_used_at, expires_at, revoked_at, secret_hash
+FROM api_keys
+WHERE id = $1`
+
+	// expireAPIKeySQL only touches a key that is unrevoked and hasn't been
+	// rotated, so two rotations of one key can't both succeed.
+	expireAPIKeySQL = `
+UPDATE api_keys
+SET expires_at = $3
+WHERE id = $1 AND project_name = $2 AND revoked_at IS NULL AND expires_at IS NULL
+RETURNING name`
+
+	revokeAPIKeySQL = `
+UPDATE api_keys
+SET revoked_at = COALESCE(revoked_at, now())
+WHERE id = $1 AND project_name = $2`
+
+	touchAPIKeySQL = `
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+)
+
+// KeyStore keeps project API keys in Postgres, where every conductor
+// instance sees a revocation as soon as it is made.
+type KeyStore struct {
+	cfg    *config.Config
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewKeyStore(cfg *config.Config, pool *pgxpool.Pool, logger *slog.Logger) *KeyStore {
+	return &KeyStore{
+		cfg:    cfg,
+		pool:   pool,
+		logger: logger.With("component", "key_store"),
+	}
+}
+
+// Create issues a new key for a project and returns it with the full key,
+// which can't be recovered later.
+func (s *KeyStore) Create(ctx context.Context, projectName, name string) (*models.APIKey, string, error) {
+	key, token, err := insertAPIKey(ctx, s.pool, projectName, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.logger.InfoContext(ctx, "created API key",
+		"project", projectName,
+		"key_id", key.ID,
+		"name", name)
+
+	return key, token, nil
+}
+
+// execer is satisfied by both the pool and a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// insertAPIKey generates a key and saves it with db, returning the key and
+// its full token.
+func insertAPIKey(ctx context.Context, db execer, projectName, name string) (*models.APIKey, string, error) {
+	idBytes := make([]byte, apiKeyIDBytes)
+	secretBytes := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key ID: %w", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key secret: %w", err)
+	}
+
+	id := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	key := &models.APIKey{
+		ID:          id,
+		ProjectName: projectName,
+		Name:        name,
+		CreatedAt:   time.Now(),
+	}
+
+	if _, err := db.Exec(ctx, insertAPIKeySQL, key.ID, key.ProjectName, key.Name, hashSecret(secret), key.CreatedAt); err != nil {
+		return nil, "", fmt.Errorf("failed to save API key: %w", err)
+	}
+
+	return key, APIKeyPrefix + id + "_" + secret, nil
+}
+
+// Get returns one of a project's keys.
+func (s *KeyStore) Get(ctx context.Context, projectName, id string) (*models.APIKey, error) {
+	rows, err := s.pool.Query(ctx, selectAPIKeyColumns+` WHERE id = $1 AND project_name = $2`, id, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API key: %w", err)
+	}
+
+	key, err := pgx.CollectOneRow(rows, scanAPIKey)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to scan API key: %w", err)
+	}
+	return key, nil
+}
+
+// List returns a project's keys, newest first, including revoked and
+// expired ones.
+func (s *KeyStore) List(ctx context.Context, projectName string) ([]*models.APIKey, error) {
+	rows, err := s.pool.Query(ctx, selectAPIKeyColumns+` WHERE project_name = $1 ORDER BY created_at DESC`, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+
+	keys, err := pgx.CollectRows(rows, scanAPIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan API keys: %w", err)
+	}
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+	return keys, nil
+}
+
+// Rotate replaces a key with a new one of the same name. The old key keeps
+// working for grace so clients can switch without an outage; a grace of zero
+// retires it immediately. Both happen in one transaction, and only if the old
+// key is unrevoked and not already rotated, so a key is never rotated twice
+// or left half-rotated.
+func (s *KeyStore) Rotate(ctx context.Context, projectName, id string, grace time.Duration) (*models.APIKey, string, error) {
+	var (
+		key    *models.APIKey
+		secret string
+	)
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var name string
+		if err := tx.QueryRow(ctx, expireAPIKeySQL, id, projectName, time.Now().Add(grace)).Scan(&name); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return fmt.Errorf("failed to expire rotated API key: %w", err)
+		}
+
+		var err error
+		key, secret, err = insertAPIKey(ctx, tx, projectName, name)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.logger.InfoContext(ctx, "rotated API key",
+		"project", projectName,
+		"key_id", id,
+		"replacement_id", key.ID,
+		"grace", grace)
+
+	return key, secret, nil
+}
+
+// Revoke stops a key from authenticating immediately.
+func (s *KeyStore) Revoke(ctx context.Context, projectName, id string) error {
+	tag, err := s.pool.Exec(ctx, revokeAPIKeySQL, id, projectName)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	s.logger.InfoContext(ctx, "revoked API key",
+		"project", projectName,
+		"key_id", id)
+
+	return nil
+}
+
+// Authenticate returns the key a presented token belongs to, or
+// ErrInvalidAPIKey if it is malformed, unknown, wrong, revoked or expired.
+func (s *KeyStore) Authenticate(ctx context.Context, token string) (*models.APIKey, error) {
+	id, secret, ok := ParseAPIKey(token)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var (
+		key  models.APIKey
+		hash []byte
+	)
+	err := s.pool.QueryRow(ctx, selectAPIKeySecretSQL, id).Scan(
+		&key.ID,
+		&key.ProjectName,
+		&key.Name,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+		&hash,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to fetch API key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare(hash, hashSecret(secret)) != 1 || !key.Active(time.Now()) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if _, err := s.pool.Exec(ctx, touchAPIKeySQL, id, time.Now().Add(-lastUsedResolution)); err != nil {
+		s.logger.WarnContext(ctx, "failed to record API key use", "key_id", id, "error", err)
+	}
+
+	return &key, nil
+}
+
+// ParseAPIKey splits a key into its ID and secret.
+func ParseAPIKey(token string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, APIKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != hex.EncodedLen(apiKeyIDBytes) || secret == "" {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// Keys are long random strings, so a fast hash is enough; there is nothing
+// for a slow one to protect against.
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func scanAPIKey(row pgx.CollectableRow) (*models.APIKey, error) {
+	var key models.APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.ProjectName,
+		&key.Name,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+	)
+	return &key, err
+}
+
+type apiKeyContextKey struct{}
+
+// NewKeyContext records the key a request was authenticated with.
+func NewKeyContext(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// KeyFromContext returns the key a request was authenticated with.
+func KeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*models.APIKey)
+	return key, ok
+}
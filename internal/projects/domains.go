@@ -0,0 +1,404 @@
+package projects
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+var (
+	ErrDomainNotFound = errors.New("custom domain not found")
+	// ErrDomainTaken means another project has verified the domain, or has
+	// a claim on it that hasn't expired.
+	ErrDomainTaken = errors.New("custom domain belongs to another project")
+	// ErrChallengeFailed means the domain's DNS didn't present the expected
+	// token.
+	ErrChallengeFailed = errors.New("domain ownership challenge failed")
+	// ErrClaimExpired means a claim lapsed before it was verified and has to
+	// be registered again.
+	ErrClaimExpired = errors.New("custom domain claim expired")
+)
+
+// domainCacheTTL bounds how long a removed or newly verified domain can be
+// routed the old way. Misses are cached too, since unknown hosts are the
+// cheapest thing for anyone to send.
+const domainCacheTTL = 30 * time.Second
+
+// maxCachedDomains bounds the cache, since senders can make up as many hosts
+// as they like.
+const maxCachedDomains = 10000
+
+// ChallengeRecordPrefix is prepended to a hostname to find its TXT challenge
+// record.
+const ChallengeRecordPrefix = "_whook-challenge."
+
+// ChallengeTXTValue is the TXT record value that proves ownership with token.
+func ChallengeTXTValue(token string) string {
+	return "whook-verification=" + token
+}
+
+// TXTResolver looks up TXT records. *net.Resolver satisfies it; tests can
+// substitute a stand-in.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type cachedDomain struct {
+	domain    *models.CustomDomain
+	expiresAt time.Time
+}
+
+type DomainStore struct {
+	cfg      *config.Config
+	rdb      *redis.Client
+	resolver TXTResolver
+	logger   *slog.Logger
+
+	mu        sync.Mutex
+	cache     map[string]cachedDomain
+	lastSweep time.Time
+}
+
+// NewDomainStore keeps custom domains in Redis. A nil resolver uses the
+// system resolver.
+func NewDomainStore(cfg *config.Config, rdb *redis.Client, resolver TXTResolver, logger *slog.Logger) *DomainStore {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &DomainStore{
+		cfg:       cfg,
+		rdb:       rdb,
+		resolver:  resolver,
+		logger:    logger.With("component", "domain_store"),
+		cache:     make(map[string]cachedDomain),
+		lastSweep: time.Now(),
+	}
+}
+
+// ProjectForDomain returns the project that has verified hostname, or "" if
+// none has.
+func (s *DomainStore) ProjectForDomain(ctx context.Context, hostname string) (string, error) {
+	domain, err := s.lookup(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	if domain == nil || !domain.Verified {
+		return "", nil
+	}
+	return domain.ProjectName, nil
+}
+
+func (s *DomainStore) lookup(ctx context.Context, hostname string) (*models.CustomDomain, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[hostname]
+	s.mu.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.domain, nil
+	}
+
+	domain, err := s.get(ctx, hostname)
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cacheLocked(hostname, domain, time.Now())
+	s.mu.Unlock()
+
+	return domain, nil
+}
+
+// cacheLocked caches a lookup, dropping expired entries every TTL and, if
+// the cache is still full, arbitrary ones to make room.
+func (s *DomainStore) cacheLocked(hostname string, domain *models.CustomDomain, now time.Time) {
+	if now.Sub(s.lastSweep) >= domainCacheTTL {
+		s.lastSweep = now
+		for name, cached := range s.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(s.cache, name)
+			}
+		}
+	}
+
+	if _, ok := s.cache[hostname]; !ok {
+		for name := range s.cache {
+			if len(s.cache) < maxCachedDomains {
+				break
+			}
+			delete(s.cache, name)
+		}
+	}
+
+	s.cache[hostname] = cachedDomain{
+		domain:    domain,
+		expiresAt: now.Add(domainCacheTTL),
+	}
+}
+
+func (s *DomainStore) get(ctx context.Context, hostname string) (*models.CustomDomain, error) {
+	domain, _, err := s.load(ctx, hostname)
+	return domain, err
+}
+
+// load returns a domain along with the stored value it was read from, which
+// replace needs to check nothing has changed since.
+func (s *DomainStore) load(ctx context.Context, hostname string) (*models.CustomDomain, string, error) {
+	raw, err := s.rdb.HGet(ctx, s.cfg.CustomDomainsKey, hostname).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, "", ErrDomainNotFound
+		}
+		return nil, "", fmt.Errorf("unable to fetch custom domain: %w", err)
+	}
+
+	var domain models.CustomDomain
+	if err := json.Unmarshal([]byte(raw), &domain); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal custom domain: %w", err)
+	}
+
+	return &domain, raw, nil
+}
+
+// Get returns a project's custom domain, verified or not.
+func (s *DomainStore) Get(ctx context.Context, projectName, hostname string) (*models.CustomDomain, error) {
+	domain, err := s.get(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if domain.ProjectName != projectName {
+		return nil, ErrDomainNotFound
+	}
+	return domain, nil
+}
+
+// List returns the custom domains registered by a project.
+func (s *DomainStore) List(ctx context.Context, projectName string) ([]*models.CustomDomain, error) {
+	all, err := s.rdb.HGetAll(ctx, s.cfg.CustomDomainsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch custom domains: %w", err)
+	}
+
+	domains := []*models.CustomDomain{}
+	for hostname, raw := range all {
+		var domain models.CustomDomain
+		if err := json.Unmarshal([]byte(raw), &domain); err != nil {
+			s.logger.ErrorContext(ctx, "failed to unmarshal custom domain",
+				"hostname", hostname,
+				"error", err)
+			continue
+		}
+		if domain.ProjectName == projectName {
+			domains = append(domains, &domain)
+		}
+	}
+
+	return domains, nil
+}
+
+// Register starts a project's claim on hostname and returns the domain with
+// the token it must present. The claim, and its token, belong to the project
+// until it is verified or expires; only then can another project claim the
+// domain.
+func (s *DomainStore) Register(ctx context.Context, projectName, hostname string) (*models.CustomDomain, error) {
+	existing, previous, err := s.load(ctx, hostname)
+	if err != nil && !errors.Is(err, ErrDomainNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	if existing != nil && !s.claimExpired(existing, now) {
+		if existing.ProjectName != projectName {
+			return nil, ErrDomainTaken
+		}
+		return existing, nil
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+
+	expiresAt := now.Add(s.cfg.CustomDomainClaimTTL)
+	domain := &models.CustomDomain{
+		Hostname:       hostname,
+		ProjectName:    projectName,
+		Token:          hex.EncodeToString(token),
+		CreatedAt:      now,
+		ClaimExpiresAt: &expiresAt,
+	}
+	// Another project may have claimed the domain since it was read.
+	if ok, err := s.replace(ctx, domain, previous); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrDomainTaken
+	}
+
+	s.logger.InfoContext(ctx, "registered custom domain",
+		"project", projectName,
+		"hostname", hostname)
+
+	return domain, nil
+}
+
+// Verify runs the given challenge against a project's domain and marks it
+// verified if the token is found.
+func (s *DomainStore) Verify(ctx context.Context, projectName, hostname, method string) (*models.CustomDomain, error) {
+	domain, previous, err := s.load(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if domain.ProjectName != projectName {
+		return nil, ErrDomainNotFound
+	}
+	if domain.Verified {
+		return domain, nil
+	}
+
+	now := time.Now()
+	if s.claimExpired(domain, now) {
+		return nil, ErrClaimExpired
+	}
+
+	switch method {
+	case models.DomainChallengeDNS:
+		err = s.checkTXT(ctx, domain)
+	default:
+		return nil, fmt.Errorf("unknown challenge method %q", method)
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "custom domain verification failed",
+			"project", projectName,
+			"hostname", hostname,
+			"method", method,
+			"error", err)
+		return nil, err
+	}
+
+	domain.Verified = true
+	domain.VerifiedAt = &now
+	domain.ClaimExpiresAt = nil
+	// The claim may have lapsed and been taken while DNS was checked.
+	if ok, err := s.replace(ctx, domain, previous); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrClaimExpired
+	}
+
+	s.logger.InfoContext(ctx, "verified custom domain",
+		"project", projectName,
+		"hostname", hostname,
+		"method", method)
+
+	return domain, nil
+}
+
+// claimExpired reports whether an unverified claim has lapsed. Claims made
+// before claims expired lapse a claim TTL after they were created.
+func (s *DomainStore) claimExpired(domain *models.CustomDomain, now time.Time) bool {
+	if domain.Verified {
+		return false
+	}
+	expiresAt := domain.CreatedAt.Add(s.cfg.CustomDomainClaimTTL)
+	if domain.ClaimExpiresAt != nil {
+		expiresAt = *domain.ClaimExpiresAt
+	}
+	return !now.Before(expiresAt)
+}
+
+func (s *DomainStore) checkTXT(ctx context.Context, domain *models.CustomDomain) error {
+	records, err := s.resolver.LookupTXT(ctx, ChallengeRecordPrefix+domain.Hostname)
+	if err != nil {
+		return fmt.Errorf("%w: unable to look up TXT record: %w", ErrChallengeFailed, err)
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == ChallengeTXTValue(domain.Token) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no TXT record at %s%s contains the token", ErrChallengeFailed, ChallengeRecordPrefix, domain.Hostname)
+}
+
+// Delete removes a project's custom domain.
+func (s *DomainStore) Delete(ctx context.Context, projectName, hostname string) error {
+	if _, err := s.Get(ctx, projectName, hostname); err != nil {
+		return err
+	}
+
+	if err := s.rdb.HDel(ctx, s.cfg.CustomDomainsKey, hostname).Err(); err != nil {
+		return fmt.Errorf("failed to delete custom domain: %w", err)
+	}
+	s.forget(hostname)
+
+	s.logger.InfoContext(ctx, "deleted custom domain",
+		"project", projectName,
+		"hostname", hostname)
+
+	return nil
+}
+
+// maxReplaceAttempts bounds how often replace retries when the domains hash
+// changes under it.
+const maxReplaceAttempts = 5
+
+// replace saves domain if its stored value is still previous, "" meaning
+// there was none, and reports whether it did. Every domain shares one hash,
+// so WATCH also trips on changes to other domains; those attempts are
+// retried.
+func (s *DomainStore) replace(ctx context.Context, domain *models.CustomDomain, previous string) (bool, error) {
+	raw, err := json.Marshal(domain)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal custom domain: %w", err)
+	}
+	defer s.forget(domain.Hostname)
+
+	key := s.cfg.CustomDomainsKey
+	for range maxReplaceAttempts {
+		saved := false
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			current, err := tx.HGet(ctx, key, domain.Hostname).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if current != previous {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, domain.Hostname, raw)
+				return nil
+			})
+			saved = err == nil
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to save custom domain: %w", err)
+		}
+		return saved, nil
+	}
+
+	return false, fmt.Errorf("failed to save custom domain: %w", redis.TxFailedErr)
+}
+
+func (s *DomainStore) forget(hostname string) {
+	s.mu.Lock()
+	delete(s.cache, hostname)
+	s.mu.Unlock()
+}
@@ -0,0 +1,307 @@
+package projects
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// txtRecords stands in for DNS, answering TXT lookups from a map.
+type txtRecords map[string][]string
+
+func (r txtRecords) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func newTestDomainStore(t *testing.T, dns txtRecords) (*DomainStore, *config.Config) {
+	t.Helper()
+
+	_, rdb := newFakeRedis(t)
+
+	cfg := &config.Config{
+		CustomDomainsKey:     "custom_domains",
+		CustomDomainClaimTTL: time.Hour,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return NewDomainStore(cfg, rdb, dns, logger), cfg
+}
+
+func TestVerify(t *testing.T) {
+	const hostname = "hooks.acme.com"
+
+	tests := []struct {
+		name    string
+		records func(token string) []string
+		method  string
+		wantErr error
+	}{
+		{
+			name:    "matching TXT record",
+			records: func(token string) []string { return []string{"unrelated", ChallengeTXTValue(token)} },
+			method:  models.DomainChallengeDNS,
+		},
+		{
+			name:    "record padded with whitespace",
+			records: func(token string) []string { return []string{" " + ChallengeTXTValue(token) + " "} },
+			method:  models.DomainChallengeDNS,
+		},
+		{
+			name:    "wrong token",
+			records: func(string) []string { return []string{ChallengeTXTValue("not-the-token")} },
+			method:  models.DomainChallengeDNS,
+			wantErr: ErrChallengeFailed,
+		},
+		{
+			name:    "bare token without prefix",
+			records: func(token string) []string { return []string{token} },
+			method:  models.DomainChallengeDNS,
+			wantErr: ErrChallengeFailed,
+		},
+		{
+			name:    "no record",
+			records: nil,
+			method:  models.DomainChallengeDNS,
+			wantErr: ErrChallengeFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dns := txtRecords{}
+			store, _ := newTestDomainStore(t, dns)
+
+			domain, err := store.Register(ctx, "acme", hostname)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if tt.records != nil {
+				dns[ChallengeRecordPrefix+hostname] = tt.records(domain.Token)
+			}
+
+			verified, err := store.Verify(ctx, "acme", hostname, tt.method)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+				}
+				if project, _ := store.ProjectForDomain(ctx, hostname); project != "" {
+					t.Fatalf("unverified domain routes to %q", project)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if !verified.Verified || verified.VerifiedAt == nil || verified.ClaimExpiresAt != nil {
+				t.Fatalf("Verify returned %+v, want a verified domain without a claim expiry", verified)
+			}
+			if project, err := store.ProjectForDomain(ctx, hostname); err != nil || project != "acme" {
+				t.Fatalf("ProjectForDomain = %q, %v; want acme", project, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherProjects(t *testing.T) {
+	ctx := context.Background()
+	dns := txtRecords{}
+	store, _ := newTestDomainStore(t, dns)
+
+	domain, err := store.Register(ctx, "acme", "hooks.acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns[ChallengeRecordPrefix+"hooks.acme.com"] = []string{ChallengeTXTValue(domain.Token)}
+
+	if _, err := store.Verify(ctx, "mallory", "hooks.acme.com", models.DomainChallengeDNS); !errors.Is(err, ErrDomainNotFound) {
+		t.Fatalf("Verify by another project = %v, want ErrDomainNotFound", err)
+	}
+	if _, err := store.Verify(ctx, "acme", "hooks.acme.com", "http"); err == nil {
+		t.Fatal("Verify accepted the http method")
+	}
+}
+
+func TestVerifyExpiredClaim(t *testing.T) {
+	ctx := context.Background()
+	dns := txtRecords{}
+	store, cfg := newTestDomainStore(t, dns)
+
+	cfg.CustomDomainClaimTTL = time.Nanosecond
+	domain, err := store.Register(ctx, "acme", "hooks.acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns[ChallengeRecordPrefix+"hooks.acme.com"] = []string{ChallengeTXTValue(domain.Token)}
+	time.Sleep(time.Millisecond)
+
+	if _, err := store.Verify(ctx, "acme", "hooks.acme.com", models.DomainChallengeDNS); !errors.Is(err, ErrClaimExpired) {
+		t.Fatalf("Verify of expired claim = %v, want ErrClaimExpired", err)
+	}
+}
+
+func TestRegisterKeepsClaimWithFirstProject(t *testing.T) {
+	ctx := context.Background()
+	dns := txtRecords{}
+	store, cfg := newTestDomainStore(t, dns)
+
+	first, err := store.Register(ctx, "acme", "hooks.acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Register(ctx, "mallory", "hooks.acme.com"); !errors.Is(err, ErrDomainTaken) {
+		t.Fatalf("Register over a pending claim = %v, want ErrDomainTaken", err)
+	}
+	again, err := store.Register(ctx, "acme", "hooks.acme.com")
+	if err != nil || again.Token != first.Token {
+		t.Fatalf("re-registering returned %+v, %v; want the original claim", again, err)
+	}
+
+	// Once the claim lapses anyone may claim the domain, with a new token.
+	cfg.CustomDomainClaimTTL = time.Nanosecond
+	stale, err := store.Register(ctx, "stale", "hooks.stale.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	taken, err := store.Register(ctx, "other", "hooks.stale.com")
+	if err != nil {
+		t.Fatalf("Register over an expired claim: %v", err)
+	}
+	if taken.ProjectName != "other" || taken.Token == stale.Token {
+		t.Fatalf("Register over an expired claim returned %+v", taken)
+	}
+
+	// A verified domain is never taken over, whatever the claim TTL.
+	cfg.CustomDomainClaimTTL = time.Hour
+	verified, err := store.Register(ctx, "acme", "hooks.verified.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns[ChallengeRecordPrefix+"hooks.verified.com"] = []string{ChallengeTXTValue(verified.Token)}
+	if _, err := store.Verify(ctx, "acme", "hooks.verified.com", models.DomainChallengeDNS); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CustomDomainClaimTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := store.Register(ctx, "mallory", "hooks.verified.com"); !errors.Is(err, ErrDomainTaken) {
+		t.Fatalf("Register over a verified domain = %v, want ErrDomainTaken", err)
+	}
+}
+
+func TestReplaceDomain(t *testing.T) {
+	const key = "custom_domains"
+	const hostname = "hooks.acme.com"
+
+	// set writes a field the way another conductor would, bumping the
+	// hash's version so watchers see it.
+	set := func(f *fakeRedis, field, value string) {
+		f.hash(key)[field] = value
+		f.versions[key]++
+	}
+
+	tests := []struct {
+		name       string
+		stored     string
+		previous   string
+		beforeExec func(f *fakeRedis, execs int)
+		wantSaved  bool
+		wantErr    error
+		wantStored string
+	}{
+		{
+			name:      "unchanged",
+			wantSaved: true,
+		},
+		{
+			name:       "changed since it was read",
+			stored:     `{"project_name":"other"}`,
+			wantStored: `{"project_name":"other"}`,
+		},
+		{
+			name:      "replacing what was read",
+			stored:    `{"project_name":"acme"}`,
+			previous:  `{"project_name":"acme"}`,
+			wantSaved: true,
+		},
+		{
+			name: "other domain changed under the transaction",
+			beforeExec: func(f *fakeRedis, execs int) {
+				if execs == 1 {
+					set(f, "hooks.other.com", "{}")
+				}
+			},
+			wantSaved: true,
+		},
+		{
+			name: "claimed under the transaction",
+			beforeExec: func(f *fakeRedis, execs int) {
+				set(f, hostname, `{"project_name":"other"}`)
+			},
+			wantStored: `{"project_name":"other"}`,
+		},
+		{
+			name: "hash never quiet",
+			beforeExec: func(f *fakeRedis, execs int) {
+				set(f, "hooks.other.com", strconv.Itoa(execs))
+			},
+			wantErr: redis.TxFailedErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f, rdb := newFakeRedis(t)
+			cfg := &config.Config{CustomDomainsKey: key, CustomDomainClaimTTL: time.Hour}
+			store := NewDomainStore(cfg, rdb, txtRecords{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			if tt.stored != "" {
+				f.hash(key)[hostname] = tt.stored
+			}
+			execs := 0
+			if tt.beforeExec != nil {
+				f.beforeExec = func(f *fakeRedis) {
+					execs++
+					tt.beforeExec(f, execs)
+				}
+			}
+
+			domain := &models.CustomDomain{Hostname: hostname, ProjectName: "acme", Token: "t"}
+			saved, err := store.replace(ctx, domain, tt.previous)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("replace error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || saved != tt.wantSaved {
+				t.Fatalf("replace = %v, %v; want %v", saved, err, tt.wantSaved)
+			}
+
+			stored := f.hash(key)[hostname]
+			if tt.wantSaved {
+				got, err := store.Get(ctx, "acme", hostname)
+				if err != nil || got.Token != "t" {
+					t.Fatalf("after replace Get = %+v, %v; want the saved domain", got, err)
+				}
+			} else if stored != tt.wantStored {
+				t.Fatalf("stored %q, want %q left alone", stored, tt.wantStored)
+			}
+		})
+	}
+}
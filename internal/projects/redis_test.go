@@ -0,0 +1,224 @@
+package projects
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis speaks just enough of the Redis protocol for the stores in this
+// package: hash commands and optimistic transactions with WATCH.
+type fakeRedis struct {
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	versions map[string]int
+
+	// beforeExec, if set, runs as EXEC arrives, before watched keys are
+	// checked, so a test can change data under a transaction.
+	beforeExec func(f *fakeRedis)
+}
+
+// fakeConn is the transaction state of one client connection.
+type fakeConn struct {
+	watched map[string]int
+	multi   bool
+	queued  [][]string
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	t.Helper()
+
+	f := &fakeRedis{
+		hashes:   make(map[string]map[string]string),
+		versions: make(map[string]int),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	rdb := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2})
+	t.Cleanup(func() { rdb.Close() })
+
+	return f, rdb
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string]string)
+	}
+	return f.hashes[key]
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var c fakeConn
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		reply := f.execConn(&c, args)
+		f.mu.Unlock()
+
+		w.WriteString(reply)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// execConn handles the transaction commands, which depend on the
+// connection, and queues commands sent inside MULTI. It must be called with
+// mu held.
+func (f *fakeRedis) execConn(c *fakeConn, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "WATCH":
+		if c.watched == nil {
+			c.watched = make(map[string]int)
+		}
+		for _, key := range args[1:] {
+			c.watched[key] = f.versions[key]
+		}
+		return "+OK\r\n"
+	case "UNWATCH":
+		c.watched = nil
+		return "+OK\r\n"
+	case "MULTI":
+		c.multi = true
+		return "+OK\r\n"
+	case "DISCARD":
+		c.multi, c.queued, c.watched = false, nil, nil
+		return "+OK\r\n"
+	case "EXEC":
+		if f.beforeExec != nil {
+			f.beforeExec(f)
+		}
+		queued, watched := c.queued, c.watched
+		c.multi, c.queued, c.watched = false, nil, nil
+		for key, version := range watched {
+			if f.versions[key] != version {
+				return "*-1\r\n"
+			}
+		}
+		reply := fmt.Sprintf("*%d\r\n", len(queued))
+		for _, args := range queued {
+			reply += f.exec(args)
+		}
+		return reply
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, args)
+		return "+QUEUED\r\n"
+	}
+	return f.exec(args)
+}
+
+// exec must be called with mu held.
+func (f *fakeRedis) exec(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "HELLO":
+		return "-ERR unknown command 'HELLO'\r\n"
+	case "PING":
+		return "+PONG\r\n"
+	case "CLIENT", "SELECT":
+		return "+OK\r\n"
+	case "HGET":
+		v, ok := f.hash(args[1])[args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulkString(v)
+	case "HSET":
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := f.hash(args[1])[args[i]]; !ok {
+				added++
+			}
+			f.hash(args[1])[args[i]] = args[i+1]
+		}
+		f.versions[args[1]]++
+		return fmt.Sprintf(":%d\r\n", added)
+	case "HDEL":
+		removed := 0
+		for _, field := range args[2:] {
+			if _, ok := f.hash(args[1])[field]; ok {
+				delete(f.hash(args[1]), field)
+				removed++
+			}
+		}
+		if removed > 0 {
+			f.versions[args[1]]++
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "HGETALL":
+		h := f.hash(args[1])
+		reply := fmt.Sprintf("*%d\r\n", 2*len(h))
+		for k, v := range h {
+			reply += bulkString(k) + bulkString(v)
+		}
+		return reply
+	}
+	return "-ERR unsupported command '" + args[0] + "'\r\n"
+}
+
+func bulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
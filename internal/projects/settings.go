@@ -0,0 +1,124 @@
+package projects
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// settingsCacheTTL bounds how long a conductor can serve stale settings after
+// another instance updates them.
+const settingsCacheTTL = 5 * time.Second
+
+// maxCachedSettings bounds the cache. Every label under a base domain looks
+// like a project, so senders can make up as many as they like.
+const maxCachedSettings = 10000
+
+type cachedSettings struct {
+	settings  *models.ProjectSettings
+	expiresAt time.Time
+}
+
+type SettingsStore struct {
+	cfg    *config.Config
+	rdb    *redis.Client
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	cache     map[string]cachedSettings
+	lastSweep time.Time
+}
+
+func NewSettingsStore(cfg *config.Config, rdb *redis.Client, logger *slog.Logger) *SettingsStore {
+	return &SettingsStore{
+		cfg:       cfg,
+		rdb:       rdb,
+		logger:    logger.With("component", "settings_store"),
+		cache:     make(map[string]cachedSettings),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the settings for a project, or empty settings if none have been
+// saved.
+func (s *SettingsStore) Get(ctx context.Context, projectName string) (*models.ProjectSettings, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[projectName]
+	s.mu.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.settings, nil
+	}
+
+	settings := &models.ProjectSettings{}
+
+	raw, err := s.rdb.HGet(ctx, s.cfg.ProjectSettingsKey, projectName).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("unable to fetch project settings: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal([]byte(raw), settings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal project settings: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.cacheLocked(projectName, settings, time.Now())
+	s.mu.Unlock()
+
+	return settings, nil
+}
+
+// cacheLocked caches a project's settings, dropping expired entries every
+// TTL and, if the cache is still full, arbitrary ones to make room.
+func (s *SettingsStore) cacheLocked(projectName string, settings *models.ProjectSettings, now time.Time) {
+	if now.Sub(s.lastSweep) >= settingsCacheTTL {
+		s.lastSweep = now
+		for name, cached := range s.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(s.cache, name)
+			}
+		}
+	}
+
+	if _, ok := s.cache[projectName]; !ok {
+		for name := range s.cache {
+			if len(s.cache) < maxCachedSettings {
+				break
+			}
+			delete(s.cache, name)
+		}
+	}
+
+	s.cache[projectName] = cachedSettings{
+		settings:  settings,
+		expiresAt: now.Add(settingsCacheTTL),
+	}
+}
+
+func (s *SettingsStore) Put(ctx context.Context, projectName string, settings *models.ProjectSettings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal project settings: %w", err)
+	}
+
+	if err := s.rdb.HSet(ctx, s.cfg.ProjectSettingsKey, projectName, raw).Err(); err != nil {
+		return fmt.Errorf("failed to save project settings: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.cache, projectName)
+	s.mu.Unlock()
+
+	s.logger.InfoContext(ctx, "updated project settings", "project", projectName)
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package projects
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+func TestSettingsCacheBounded(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewSettingsStore(&config.Config{}, nil, logger)
+	settings := &models.ProjectSettings{}
+	now := time.Now()
+
+	for i := range maxCachedSettings + 100 {
+		s.cacheLocked(fmt.Sprintf("made-up-%d", i), settings, now)
+	}
+	if len(s.cache) != maxCachedSettings {
+		t.Fatalf("cache holds %d entries, want %d", len(s.cache), maxCachedSettings)
+	}
+
+	// Refreshing a cached project never evicts another.
+	s.cacheLocked(fmt.Sprintf("made-up-%d", maxCachedSettings+99), settings, now)
+	if len(s.cache) != maxCachedSettings {
+		t.Fatalf("refreshing an entry changed the cache to %d entries", len(s.cache))
+	}
+
+	// Once they have expired, the next write sweeps them all out.
+	s.cacheLocked("acme", settings, now.Add(settingsCacheTTL))
+	if len(s.cache) != 1 {
+		t.Fatalf("cache holds %d entries after a sweep, want 1", len(s.cache))
+	}
+}
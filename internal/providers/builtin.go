@@ -0,0 +1,187 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type GitHub struct{}
+
+func (GitHub) Name() string { return "github" }
+
+func (GitHub) Detect(header http.Header, body []byte) (Detection, bool) {
+	event := header.Get("X-GitHub-Event")
+	if event == "" {
+		return Detection{}, false
+	}
+
+	return Detection{
+		Provider:   "github",
+		EventType:  event,
+		DeliveryID: header.Get("X-GitHub-Delivery"),
+	}, true
+}
+
+type GitLab struct{}
+
+func (GitLab) Name() string { return "gitlab" }
+
+func (GitLab) Detect(header http.Header, body []byte) (Detection, bool) {
+	event := header.Get("X-Gitlab-Event")
+	if event == "" {
+		return Detection{}, false
+	}
+
+	return Detection{
+		Provider:   "gitlab",
+		EventType:  event,
+		DeliveryID: header.Get("X-Gitlab-Event-UUID"),
+	}, true
+}
+
+type Stripe struct{}
+
+func (Stripe) Name() string { return "stripe" }
+
+func (Stripe) Detect(header http.Header, body []byte) (Detection, bool) {
+	if header.Get("Stripe-Signature") == "" {
+		return Detection{}, false
+	}
+
+	var payload struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &payload)
+
+	return Detection{
+		Provider:   "stripe",
+		EventType:  payload.Type,
+		DeliveryID: payload.ID,
+	}, true
+}
+
+type Slack struct{}
+
+func (Slack) Name() string { return "slack" }
+
+func (Slack) Detect(header http.Header, body []byte) (Detection, bool) {
+	if header.Get("X-Slack-Signature") == "" {
+		return Detection{}, false
+	}
+
+	// Interactive payloads arrive form-encoded with the JSON in a single
+	// field; the Events API posts JSON directly.
+	if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			if payload := form.Get("payload"); payload != "" {
+				body = []byte(payload)
+			} else if command := form.Get("command"); command != "" {
+				return Detection{
+					Provider:   "slack",
+					EventType:  "slash_command",
+					DeliveryID: form.Get("trigger_id"),
+				}, true
+			}
+		}
+	}
+
+	var payload struct {
+		Type    string `json:"type"`
+		EventID string `json:"event_id"`
+		Event   struct {
+			Type string `json:"type"`
+		} `json:"event"`
+	}
+	json.Unmarshal(body, &payload)
+
+	eventType := payload.Type
+	if payload.Type == "event_callback" && payload.Event.Type != "" {
+		eventType = payload.Event.Type
+	}
+
+	return Detection{
+		Provider:   "slack",
+		EventType:  eventType,
+		DeliveryID: payload.EventID,
+	}, true
+}
+
+type Shopify struct{}
+
+func (Shopify) Name() string { return "shopify" }
+
+func (Shopify) Detect(header http.Header, body []byte) (Detection, bool) {
+	topic := header.Get("X-Shopify-Topic")
+	if topic == "" {
+		return Detection{}, false
+	}
+
+	deliveryID := header.Get("X-Shopify-Webhook-Id")
+	if deliveryID == "" {
+		deliveryID = header.Get("X-Shopify-Event-Id")
+	}
+
+	return Detection{
+		Provider:   "shopify",
+		EventType:  topic,
+		DeliveryID: deliveryID,
+	}, true
+}
+
+type Twilio struct{}
+
+func (Twilio) Name() string { return "twilio" }
+
+func (Twilio) Detect(header http.Header, body []byte) (Detection, bool) {
+	if header.Get("X-Twilio-Signature") == "" {
+		return Detection{}, false
+	}
+
+	detection := Detection{
+		Provider:   "twilio",
+		DeliveryID: header.Get("I-Twilio-Idempotency-Token"),
+	}
+
+	if form, err := url.ParseQuery(string(body)); err == nil {
+		switch {
+		case form.Get("EventType") != "":
+			detection.EventType = form.Get("EventType")
+		case form.Get("MessageStatus") != "":
+			detection.EventType = "message." + form.Get("MessageStatus")
+		case form.Get("CallStatus") != "":
+			detection.EventType = "call." + form.Get("CallStatus")
+		}
+	}
+
+	return detection, true
+}
+
+// StandardWebhooks covers senders following the Standard Webhooks spec,
+// including Svix, which uses its own header prefix.
+type StandardWebhooks struct{}
+
+func (StandardWebhooks) Name() string { return "standard_webhooks" }
+
+func (StandardWebhooks) Detect(header http.Header, body []byte) (Detection, bool) {
+	provider, id := "standard_webhooks", header.Get("Webhook-Id")
+	if id == "" {
+		provider, id = "svix", header.Get("Svix-Id")
+	}
+	if id == "" {
+		return Detection{}, false
+	}
+
+	var payload struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &payload)
+
+	return Detection{
+		Provider:   provider,
+		EventType:  payload.Type,
+		DeliveryID: id,
+	}, true
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Detection describes which webhook provider sent a request.
+type Detection struct {
+	Provider   string
+	EventType  string
+	DeliveryID string
+}
+
+// Detector recognises requests from a single provider. Detect reports false
+// when the request doesn't look like it came from that provider.
+type Detector interface {
+	Name() string
+	Detect(header http.Header, body []byte) (Detection, bool)
+}
+
+// DetectorFunc adapts a plain function into a Detector.
+type DetectorFunc struct {
+	ProviderName string
+	Fn           func(header http.Header, body []byte) (Detection, bool)
+}
+
+func (f DetectorFunc) Name() string {
+	return f.ProviderName
+}
+
+func (f DetectorFunc) Detect(header http.Header, body []byte) (Detection, bool) {
+	return f.Fn(header, body)
+}
+
+// Registry runs detectors in registration order and returns the first match.
+type Registry struct {
+	mu        sync.RWMutex
+	detectors []Detector
+}
+
+func NewRegistry(detectors ...Detector) *Registry {
+	return &Registry{detectors: detectors}
+}
+
+// DefaultRegistry returns a registry holding the built-in detectors.
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		GitHub{},
+		GitLab{},
+		Stripe{},
+		Slack{},
+		Shopify{},
+		Twilio{},
+		StandardWebhooks{},
+	)
+}
+
+// Register adds a detector ahead of those already registered, so custom
+// detectors can override the built-ins.
+func (r *Registry) Register(d Detector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.detectors = append([]Detector{d}, r.detectors...)
+}
+
+func (r *Registry) Detect(header http.Header, body []byte) (Detection, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, d := range r.detectors {
+		if detection, ok := d.Detect(header, body); ok {
+			if detection.Provider == "" {
+				detection.Provider = d.Name()
+			}
+			return detection, true
+		}
+	}
+
+	return Detection{}, false
+}
@@ -0,0 +1,236 @@
+package relaytls
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+)
+
+var ErrFingerprintMismatch = errors.New("relay certificate does not match advertised fingerprint")
+
+// Manager holds the client certificate and trusted CA used for mutual TLS
+// with relays, reloading them when the files on disk change.
+type Manager struct {
+	cfg    *config.Config
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func New(cfg *config.Config, logger *slog.Logger) (*Manager, error) {
+	if (cfg.RelayTLSCertFile == "") != (cfg.RelayTLSKeyFile == "") {
+		return nil, errors.New("relay TLS certificate and key must be configured together")
+	}
+	if cfg.RelayTLSReloadInterval <= 0 {
+		return nil, errors.New("relay TLS reload interval must be positive")
+	}
+
+	m := &Manager{
+		cfg:      cfg,
+		logger:   logger.With("component", "relay_tls"),
+		modTimes: make(map[string]time.Time),
+	}
+
+	if m.Enabled() {
+		if err := m.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Enabled reports whether any relay TLS material has been configured.
+func (m *Manager) Enabled() bool {
+	return m.cfg.RelayTLSCertFile != "" || m.cfg.RelayTLSCAFile != ""
+}
+
+// Start polls the certificate files and reloads them when they change, so
+// rotated certificates are picked up without a restart.
+func (m *Manager) Start(ctx context.Context) {
+	if !m.Enabled() {
+		return
+	}
+
+	m.logger.Info("watching relay TLS files", "interval", m.cfg.RelayTLSReloadInterval)
+
+	go func() {
+		ticker := time.NewTicker(m.cfg.RelayTLSReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !m.changed() {
+					continue
+				}
+				if err := m.load(); err != nil {
+					m.logger.Error("failed to reload relay TLS files, keeping previous", "error", err)
+					continue
+				}
+				m.logger.Info("reloaded relay TLS files")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// ClientConfig returns a TLS config for connecting to a relay. If the relay
+// advertised a certificate fingerprint, the connection is accepted when the
+// relay's leaf certificate matches it, and only then; the chain isn't
+// verified, so a pinned relay may use a self-signed certificate and needs no
+// CA. Otherwise the chain must verify against the configured CA, or the
+// system roots if there is none.
+func (m *Manager) ClientConfig(fingerprint string) *tls.Config {
+	pin := NormalizeFingerprint(fingerprint)
+
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: m.getClientCertificate,
+		// Chain verification happens in VerifyConnection so it always uses
+		// the most recently loaded CA pool.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return m.verifyConnection(cs, pin)
+		},
+	}
+}
+
+func (m *Manager) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		// Sending no certificate lets the relay decide whether that's fatal.
+		return &tls.Certificate{}, nil
+	}
+	return m.cert, nil
+}
+
+func (m *Manager) verifyConnection(cs tls.ConnectionState, pin string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("relay presented no certificate")
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if pin != "" {
+		sum := sha256.Sum256(leaf.Raw)
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(pin)) != 1 {
+			return ErrFingerprintMismatch
+		}
+		return nil
+	}
+
+	m.mu.RLock()
+	roots := m.roots
+	m.mu.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	}); err != nil {
+		return fmt.Errorf("verifying relay certificate: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) load() error {
+	var (
+		cert  *tls.Certificate
+		roots *x509.CertPool
+	)
+
+	if m.cfg.RelayTLSCertFile != "" {
+		pair, err := tls.LoadX509KeyPair(m.cfg.RelayTLSCertFile, m.cfg.RelayTLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading relay client certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	if m.cfg.RelayTLSCAFile != "" {
+		pem, err := os.ReadFile(m.cfg.RelayTLSCAFile)
+		if err != nil {
+			return fmt.Errorf("reading relay CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return errors.New("relay CA file contains no certificates")
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, path := range m.files() {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.roots = roots
+	m.modTimes = modTimes
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Manager) changed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, path := range m.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(m.modTimes[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Manager) files() []string {
+	var files []string
+	for _, path := range []string{m.cfg.RelayTLSCertFile, m.cfg.RelayTLSKeyFile, m.cfg.RelayTLSCAFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// NormalizeFingerprint accepts the common ways of writing a SHA-256
+// fingerprint ("sha256:AB:CD...", "abcd...") and returns lowercase hex.
+func NormalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if prefix, rest, ok := strings.Cut(fingerprint, ":"); ok && strings.EqualFold(prefix, "sha256") {
+		fingerprint = rest
+	}
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
@@ -0,0 +1,89 @@
+package relaytls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+)
+
+func TestClientConfig(t *testing.T) {
+	// httptest's certificate is self-signed, like a relay that relies on
+	// pinning rather than a CA.
+	relay := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer relay.Close()
+
+	sum := sha256.Sum256(relay.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: relay.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		caFile  string
+		pin     string
+		wantErr error
+		ok      bool
+	}{
+		{name: "pin without a CA", pin: fingerprint, ok: true},
+		{name: "pin written with colons", pin: "SHA256:" + colons(strings.ToUpper(fingerprint)), ok: true},
+		{name: "pin alongside a CA", caFile: caFile, pin: fingerprint, ok: true},
+		{name: "wrong pin", pin: strings.Repeat("0", 64), wantErr: ErrFingerprintMismatch},
+		// A pin is the relay's own claim about its certificate, so a
+		// mismatch isn't rescued by a chain that happens to verify.
+		{name: "wrong pin with a CA", caFile: caFile, pin: strings.Repeat("0", 64), wantErr: ErrFingerprintMismatch},
+		{name: "CA without a pin", caFile: caFile, ok: true},
+		{name: "neither", ok: false},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{RelayTLSCAFile: tt.caFile, RelayTLSReloadInterval: time.Minute}
+			m, err := New(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: m.ClientConfig(tt.pin)}}
+			resp, err := client.Get(relay.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("Get = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// colons writes a hex fingerprint as colon-separated byte pairs.
+func colons(fingerprint string) string {
+	pairs := make([]string, 0, len(fingerprint)/2)
+	for i := 0; i+1 < len(fingerprint); i += 2 {
+		pairs = append(pairs, fingerprint[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}
@@ -0,0 +1,123 @@
+// Package requestid assigns every inbound webhook an ID that follows it
+// through logs, storage and the relay.
+package requestid
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/whookdev/conductor/internal/clientip"
+)
+
+// Header carries the request ID back to senders and on to relays.
+const Header = "X-Whook-Request-Id"
+
+// incomingHeader is where proxies in front of the conductor put the ID they
+// assigned.
+const incomingHeader = "X-Request-Id"
+
+const maxLength = 128
+
+const (
+	logKey            = "request_id"
+	correlationLogKey = "correlation_id"
+)
+
+type (
+	contextKey            struct{}
+	correlationContextKey struct{}
+)
+
+// New returns a fresh ULID, which sorts by time.
+func New() string {
+	return ulid.Make().String()
+}
+
+// FromProxy returns the ID a trusted proxy assigned to r, or "" if there is
+// none or the sender isn't trusted. It is only ever a correlation ID: proxies
+// may reuse IDs, so the conductor always assigns its own.
+func FromProxy(r *http.Request, trusted []netip.Prefix) string {
+	if id := r.Header.Get(incomingHeader); valid(id) && clientip.Trusted(trusted, r.RemoteAddr) {
+		return id
+	}
+	return ""
+}
+
+// valid keeps incoming IDs short and free of anything that could break a
+// header, a log line or a URL.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+func NewCorrelationContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationContextKey{}, id)
+}
+
+// CorrelationFromContext returns the proxy's ID carried by ctx, or "" if
+// there is none.
+func CorrelationFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationContextKey{}).(string)
+	return id
+}
+
+// Handler adds the request ID, and the proxy's correlation ID if there is
+// one, from the context to every record logged with one of the logger's
+// Context methods.
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{Handler: h}
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := FromContext(ctx); id != "" && !hasAttr(r, logKey) {
+		r.AddAttrs(slog.String(logKey, id))
+	}
+	if id := CorrelationFromContext(ctx); id != "" && !hasAttr(r, correlationLogKey) {
+		r.AddAttrs(slog.String(correlationLogKey, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
+
+func hasAttr(r slog.Record, key string) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		found = a.Key == key
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,369 @@
+// Package routing works out what an inbound request is addressed to from its
+// Host header, so that every handler agrees on the project it belongs to.
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrForeignHost means the host is neither under a base domain nor a
+	// verified custom domain.
+	ErrForeignHost = errors.New("host is not served by the conductor")
+	// ErrInvalidProject means the label in front of the base domain can't be
+	// a project name.
+	ErrInvalidProject = errors.New("invalid project name")
+	// ErrReservedName means the name belongs to the service itself.
+	ErrReservedName = errors.New("project name is reserved")
+)
+
+// apiLabel is the subdomain serving the conductor's own API.
+const apiLabel = "api"
+
+// reserved names can never be claimed by a project.
+var reserved = map[string]bool{
+	apiLabel: true,
+	"www":    true,
+}
+
+const maxLabelLength = 63
+
+// Mode is how the project a webhook is for is found under a base domain.
+type Mode string
+
+const (
+	// ModeSubdomain reads the project from the host, <project>.<base domain>,
+	// or from a verified custom domain.
+	ModeSubdomain Mode = "subdomain"
+	// ModePath reads the project from a /p/{project}/ path prefix, for
+	// environments without wildcard DNS or certificates. Every other path
+	// is the API.
+	ModePath Mode = "path"
+)
+
+// ParseMode validates a configured routing mode.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeSubdomain, ModePath:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown routing mode %q", s)
+	}
+}
+
+// PathPrefix introduces the project name in path mode.
+const PathPrefix = "/p/"
+
+type Kind int
+
+const (
+	// KindProject addresses a project's webhook endpoint.
+	KindProject Kind = iota + 1
+	// KindAPI addresses the conductor's API.
+	KindAPI
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindProject:
+		return "project"
+	case KindAPI:
+		return "api"
+	default:
+		return "unknown"
+	}
+}
+
+// Route is what a request is addressed to. Host is normalised: lower case,
+// without a port or trailing dot. BaseDomain is the base domain the host
+// matched, and is empty when the project was found through one of its own
+// hostnames, in which case CustomDomain is set.
+type Route struct {
+	Kind         Kind
+	Host         string
+	BaseDomain   string
+	Project      string
+	CustomDomain bool
+}
+
+// DomainLookup finds the project that has verified a custom domain. It
+// returns "" if no project has.
+type DomainLookup interface {
+	ProjectForDomain(ctx context.Context, hostname string) (string, error)
+}
+
+// BaseDomain is a domain webhooks arrive under and the mode that finds the
+// project beneath it.
+type BaseDomain struct {
+	Name string
+	Mode Mode
+}
+
+type Resolver struct {
+	baseDomains []BaseDomain
+	domains     DomainLookup
+}
+
+// NewResolver returns a resolver for hosts under the given base domains.
+// domains may be nil, in which case every other host is foreign.
+func NewResolver(baseDomains []BaseDomain, domains DomainLookup) (*Resolver, error) {
+	if len(baseDomains) == 0 {
+		return nil, errors.New("at least one base domain is required")
+	}
+
+	normalized := make([]BaseDomain, 0, len(baseDomains))
+	for _, base := range baseDomains {
+		name, err := NormalizeHost(base.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base domain %q: %w", base.Name, err)
+		}
+		normalized = append(normalized, BaseDomain{Name: name, Mode: base.Mode})
+	}
+	// The most specific domain wins when one is nested under another.
+	slices.SortStableFunc(normalized, func(a, b BaseDomain) int {
+		return len(b.Name) - len(a.Name)
+	})
+
+	return &Resolver{baseDomains: normalized, domains: domains}, nil
+}
+
+// ResolveRequest works out what req is addressed to and returns it with the
+// route attached to its context.
+//
+// Under a subdomain-mode base domain only hosts exactly one label below it
+// are accepted, so neither "evilwhook.dev" nor "a.b.whook.dev" resolve
+// against "whook.dev". Under a path-mode base domain the project comes from
+// a /p/{project} prefix, which is removed so storage, forwarding and signing
+// see the same path they would in subdomain mode. Any other host is looked
+// up as a custom domain.
+func (r *Resolver) ResolveRequest(req *http.Request) (*http.Request, Route, error) {
+	host, err := NormalizeHost(req.Host)
+	if err != nil {
+		return req, Route{}, err
+	}
+
+	var route Route
+	base, label, found := r.match(host)
+	switch {
+	case !found:
+		route, err = r.resolveCustom(req.Context(), host)
+	case base.Mode == ModePath && label == "":
+		route, err = resolvePath(host, req.URL.EscapedPath())
+	case base.Mode == ModePath:
+		err = fmt.Errorf("%w: %q is below a path-routed domain", ErrForeignHost, host)
+	default:
+		route, err = resolveSubdomain(host, label)
+	}
+	if err != nil {
+		return req, Route{}, err
+	}
+	if found {
+		route.BaseDomain = base.Name
+	}
+
+	req = req.WithContext(NewContext(req.Context(), route))
+	if base.Mode == ModePath && route.Kind == KindProject {
+		req = stripProject(req, route.Project)
+	}
+	return req, route, nil
+}
+
+// VerifiedCustomDomain reports whether a host is a custom domain a project
+// has verified, and so whether a certificate may be issued for it. Names
+// under a base domain are served by its wildcard certificate instead, since
+// anyone can make up a project label and have the CA asked for it.
+func (r *Resolver) VerifiedCustomDomain(ctx context.Context, hostport string) error {
+	host, err := NormalizeHost(hostport)
+	if err != nil {
+		return err
+	}
+	if r.UnderBaseDomain(host) {
+		return fmt.Errorf("%w: %q is under a base domain", ErrForeignHost, host)
+	}
+
+	_, err = r.resolveCustom(ctx, host)
+	return err
+}
+
+// match finds the base domain host is, or is under, and the labels in front
+// of it.
+func (r *Resolver) match(host string) (BaseDomain, string, bool) {
+	for _, base := range r.baseDomains {
+		if host == base.Name {
+			return base, "", true
+		}
+		if label, ok := strings.CutSuffix(host, "."+base.Name); ok {
+			return base, label, true
+		}
+	}
+	return BaseDomain{}, "", false
+}
+
+func resolveSubdomain(host, label string) (Route, error) {
+	if label == "" {
+		return Route{}, fmt.Errorf("%w: %q has no project", ErrForeignHost, host)
+	}
+	if strings.Contains(label, ".") {
+		return Route{}, fmt.Errorf("%w: %q has more than one label", ErrInvalidProject, label)
+	}
+
+	if label == apiLabel {
+		return Route{Kind: KindAPI, Host: host}, nil
+	}
+	if err := ValidateProjectName(label); err != nil {
+		return Route{}, err
+	}
+
+	return Route{Kind: KindProject, Host: host, Project: label}, nil
+}
+
+// resolvePath finds the project in an escaped path. The name is a single
+// segment, so an encoded slash can't smuggle part of the path into it.
+func resolvePath(host, escapedPath string) (Route, error) {
+	rest, ok := strings.CutPrefix(escapedPath, PathPrefix)
+	if !ok {
+		return Route{Kind: KindAPI, Host: host}, nil
+	}
+
+	segment, _, _ := strings.Cut(rest, "/")
+	project, err := url.PathUnescape(segment)
+	if err != nil {
+		return Route{}, fmt.Errorf("%w: %q is not a valid path segment", ErrInvalidProject, segment)
+	}
+	if err := ValidateProjectName(project); err != nil {
+		return Route{}, err
+	}
+
+	return Route{Kind: KindProject, Host: host, Project: project}, nil
+}
+
+// stripProject removes /p/{project} from the request's path and raw request
+// URI, leaving at least "/". Both are cut at the same escaped segment, so
+// they still agree when the sender encoded the project name.
+func stripProject(req *http.Request, project string) *http.Request {
+	rest, ok := cutProjectSegment(req.URL.EscapedPath(), project)
+	if !ok {
+		return req
+	}
+	path, err := url.PathUnescape(rest)
+	if err != nil {
+		return req
+	}
+
+	u := *req.URL
+	u.Path, u.RawPath = path, ""
+	if u.EscapedPath() != rest {
+		u.RawPath = rest
+	}
+	req.URL = &u
+
+	if uriPath, query, hasQuery := strings.Cut(req.RequestURI, "?"); strings.HasPrefix(uriPath, "/") {
+		req.RequestURI = u.EscapedPath()
+		if rest, ok := cutProjectSegment(uriPath, project); ok {
+			req.RequestURI = rest
+		}
+		if hasQuery {
+			req.RequestURI += "?" + query
+		}
+	}
+	return req
+}
+
+// cutProjectSegment removes the /p/ prefix and the segment naming project
+// from an escaped path.
+func cutProjectSegment(escapedPath, project string) (string, bool) {
+	rest, ok := strings.CutPrefix(escapedPath, PathPrefix)
+	if !ok {
+		return "", false
+	}
+	segment, rest, _ := strings.Cut(rest, "/")
+	if name, err := url.PathUnescape(segment); err != nil || name != project {
+		return "", false
+	}
+	return "/" + rest, true
+}
+
+func (r *Resolver) resolveCustom(ctx context.Context, host string) (Route, error) {
+	if r.domains == nil {
+		return Route{}, fmt.Errorf("%w: %q", ErrForeignHost, host)
+	}
+
+	project, err := r.domains.ProjectForDomain(ctx, host)
+	if err != nil {
+		return Route{}, fmt.Errorf("failed to look up custom domain %q: %w", host, err)
+	}
+	if project == "" {
+		return Route{}, fmt.Errorf("%w: %q", ErrForeignHost, host)
+	}
+
+	return Route{Kind: KindProject, Host: host, Project: project, CustomDomain: true}, nil
+}
+
+// UnderBaseDomain reports whether a normalised host is a base domain or any
+// name below one, none of which can be registered as a custom domain.
+func (r *Resolver) UnderBaseDomain(host string) bool {
+	_, _, found := r.match(host)
+	return found
+}
+
+// ValidateProjectName checks that name can be used as a project's subdomain:
+// a DNS label of lower-case letters, digits and inner hyphens that isn't
+// reserved.
+func ValidateProjectName(name string) error {
+	if name == "" || len(name) > maxLabelLength {
+		return fmt.Errorf("%w: must be 1 to %d characters", ErrInvalidProject, maxLabelLength)
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' && i > 0 && i < len(name)-1:
+		default:
+			return fmt.Errorf("%w: %q must be lower-case letters, digits and inner hyphens", ErrInvalidProject, name)
+		}
+	}
+	if reserved[name] {
+		return fmt.Errorf("%w: %q", ErrReservedName, name)
+	}
+	return nil
+}
+
+// NormalizeHost strips any port and a single trailing dot and lower-cases
+// what is left, rejecting anything that isn't a plausible DNS name.
+func NormalizeHost(hostport string) (string, error) {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if host == "" || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+		return "", fmt.Errorf("%w: %q", ErrForeignHost, hostport)
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return "", fmt.Errorf("%w: %q", ErrForeignHost, hostport)
+		}
+	}
+	return host, nil
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, contextKey{}, route)
+}
+
+// FromContext returns the route the server resolved for a request.
+func FromContext(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(contextKey{}).(Route)
+	return route, ok
+}
@@ -0,0 +1,274 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type staticDomains map[string]string
+
+func (d staticDomains) ProjectForDomain(_ context.Context, hostname string) (string, error) {
+	return d[hostname], nil
+}
+
+func newTestResolver(t testing.TB) *Resolver {
+	t.Helper()
+
+	r, err := NewResolver([]BaseDomain{
+		{Name: "whook.dev", Mode: ModeSubdomain},
+		{Name: "hooks.example.com", Mode: ModePath},
+	}, staticDomains{"hooks.acme.com": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// newTestRequest builds a request as the server would from its request line,
+// so URL and RequestURI agree.
+func newTestRequest(host, target string) (*http.Request, error) {
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method:     http.MethodPost,
+		Host:       host,
+		URL:        u,
+		RequestURI: target,
+	}
+	return req.WithContext(context.Background()), nil
+}
+
+func FuzzNormalizeHost(f *testing.F) {
+	for _, seed := range []string{
+		"foo.whook.dev",
+		"FOO.Whook.DEV",
+		"foo.whook.dev:8443",
+		"foo.whook.dev.",
+		"foo.whook.dev..",
+		"foo..whook.dev",
+		".whook.dev",
+		"[::1]:80",
+		"foo_bar.whook.dev",
+		"",
+		".",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, hostport string) {
+		host, err := NormalizeHost(hostport)
+		if err != nil {
+			if !errors.Is(err, ErrForeignHost) {
+				t.Fatalf("NormalizeHost(%q) error %v is not ErrForeignHost", hostport, err)
+			}
+			return
+		}
+
+		if host == "" || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+			t.Fatalf("NormalizeHost(%q) = %q, want a name without empty labels", hostport, host)
+		}
+		for i := 0; i < len(host); i++ {
+			if c := host[i]; !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+				t.Fatalf("NormalizeHost(%q) = %q, contains %q", hostport, host, c)
+			}
+		}
+
+		// Ports, case and a trailing dot never change the result.
+		for _, variant := range []string{host, strings.ToUpper(host), host + ".", net.JoinHostPort(host, "8443")} {
+			got, err := NormalizeHost(variant)
+			if err != nil || got != host {
+				t.Fatalf("NormalizeHost(%q) = %q, %v; want %q", variant, got, err, host)
+			}
+		}
+	})
+}
+
+func FuzzResolveRequest(f *testing.F) {
+	for _, seed := range []struct{ host, path string }{
+		{"foo.whook.dev", "/hook"},
+		{"FOO.whook.dev:443", "/hook"},
+		{"foo.whook.dev.", "/hook"},
+		{"evilwhook.dev", "/hook"},
+		{"foo.evilwhook.dev", "/hook"},
+		{"a.b.whook.dev", "/hook"},
+		{"api.whook.dev", "/relay"},
+		{"www.whook.dev", "/"},
+		{"whook.dev", "/"},
+		{"hooks.example.com", "/p/foo/hook?x=1"},
+		{"hooks.example.com", "/p/api/hook"},
+		{"hooks.example.com", "/p/%66oo/hook"},
+		{"hooks.example.com", "/p/foo%2Fbar/hook"},
+		{"hooks.example.com", "/p/foo/a%2Fb?x=%20"},
+		{"hooks.example.com", "/p/foo"},
+		{"hooks.example.com", "/relay"},
+		{"foo.hooks.example.com", "/p/foo/hook"},
+		{"hooks.acme.com", "/hook"},
+		{"HOOKS.ACME.COM.", "/hook"},
+	} {
+		f.Add(seed.host, seed.path)
+	}
+
+	resolver := newTestResolver(f)
+
+	f.Fuzz(func(t *testing.T, host, path string) {
+		req, err := newTestRequest(host, path)
+		if err != nil {
+			return
+		}
+		resolved, route, err := resolver.ResolveRequest(req)
+		if err != nil {
+			return
+		}
+
+		normalized, nerr := NormalizeHost(host)
+		if nerr != nil {
+			t.Fatalf("resolved %q, which doesn't normalise: %v", host, nerr)
+		}
+		if route.Host != normalized {
+			t.Fatalf("route host = %q, want %q", route.Host, normalized)
+		}
+
+		switch route.Kind {
+		case KindAPI:
+			if route.Project != "" {
+				t.Fatalf("API route for %q carries project %q", host, route.Project)
+			}
+		case KindProject:
+			if err := ValidateProjectName(route.Project); err != nil && !route.CustomDomain {
+				t.Fatalf("resolved %q%s to invalid project %q: %v", host, path, route.Project, err)
+			}
+		default:
+			t.Fatalf("resolved %q to kind %v", host, route.Kind)
+		}
+
+		switch route.BaseDomain {
+		case "":
+			if !route.CustomDomain || route.Host != "hooks.acme.com" || route.Project != "acme" {
+				t.Fatalf("resolved %q outside every base domain: %+v", host, route)
+			}
+		case "whook.dev":
+			// Exactly one label, separated by a dot, in front of the base.
+			label, ok := strings.CutSuffix(route.Host, ".whook.dev")
+			if !ok || label == "" || strings.Contains(label, ".") {
+				t.Fatalf("resolved %q under whook.dev: %+v", host, route)
+			}
+			if route.Kind == KindProject && route.Project != label {
+				t.Fatalf("resolved %q to project %q", host, route.Project)
+			}
+		case "hooks.example.com":
+			if route.Host != "hooks.example.com" {
+				t.Fatalf("resolved %q under a path-routed domain: %+v", host, route)
+			}
+			if route.Kind == KindProject && strings.HasPrefix(resolved.URL.Path, PathPrefix+route.Project+"/") {
+				t.Fatalf("%s left the project in the path: %q", path, resolved.URL.Path)
+			}
+		default:
+			t.Fatalf("resolved %q under unknown base domain %q", host, route.BaseDomain)
+		}
+
+		// Whatever was stripped, the raw request URI still names the same
+		// path as the URL, since that's what gets forwarded.
+		uriPath, _, _ := strings.Cut(resolved.RequestURI, "?")
+		if uriPath != resolved.URL.EscapedPath() {
+			t.Fatalf("%s resolved to RequestURI %q but escaped path %q", path, resolved.RequestURI, resolved.URL.EscapedPath())
+		}
+		if unescaped, err := url.PathUnescape(uriPath); err != nil || unescaped != resolved.URL.Path {
+			t.Fatalf("%s resolved to RequestURI %q but path %q", path, resolved.RequestURI, resolved.URL.Path)
+		}
+		if resolved.URL.RawQuery != req.URL.RawQuery {
+			t.Fatalf("%s resolved with query %q", path, resolved.URL.RawQuery)
+		}
+
+		// Ports, case and a trailing dot never change the route.
+		for _, variant := range []string{strings.ToUpper(normalized), normalized + ".", net.JoinHostPort(normalized, "8443")} {
+			req, _ := newTestRequest(variant, path)
+			_, got, err := resolver.ResolveRequest(req)
+			if err != nil || got != route {
+				t.Fatalf("%q resolved to %+v, %v; %q resolved to %+v", variant, got, err, host, route)
+			}
+		}
+	})
+}
+
+func FuzzValidateProjectName(f *testing.F) {
+	for _, seed := range []string{
+		"foo",
+		"foo-bar",
+		"-foo",
+		"foo-",
+		"Foo",
+		"foo.bar",
+		"foo_bar",
+		"api",
+		"www",
+		"",
+		strings.Repeat("a", 63),
+		strings.Repeat("a", 64),
+	} {
+		f.Add(seed)
+	}
+
+	resolver := newTestResolver(f)
+
+	f.Fuzz(func(t *testing.T, name string) {
+		err := ValidateProjectName(name)
+		if reserved[name] {
+			if !errors.Is(err, ErrReservedName) {
+				t.Fatalf("ValidateProjectName(%q) = %v, want ErrReservedName", name, err)
+			}
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidProject) {
+				t.Fatalf("ValidateProjectName(%q) error %v is not ErrInvalidProject", name, err)
+			}
+			return
+		}
+
+		if len(name) > maxLabelLength || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+			t.Fatalf("ValidateProjectName(%q) accepted a name that isn't a DNS label", name)
+		}
+		if host, err := NormalizeHost(name); err != nil || host != name {
+			t.Fatalf("valid project %q normalises to %q, %v", name, host, err)
+		}
+
+		// Every valid name is reachable in both routing modes.
+		req, _ := newTestRequest(name+".whook.dev", "/")
+		_, route, err := resolver.ResolveRequest(req)
+		if err != nil || route.Kind != KindProject || route.Project != name {
+			t.Fatalf("%q.whook.dev resolved to %+v, %v", name, route, err)
+		}
+		req, _ = newTestRequest("hooks.example.com", PathPrefix+name+"/hook")
+		_, route, err = resolver.ResolveRequest(req)
+		if err != nil || route.Kind != KindProject || route.Project != name {
+			t.Fatalf("hooks.example.com%s%s/hook resolved to %+v, %v", PathPrefix, name, route, err)
+		}
+	})
+}
+
+func TestVerifiedCustomDomain(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	for host, want := range map[string]bool{
+		"hooks.acme.com":        true,
+		"HOOKS.ACME.COM.":       true,
+		"hooks.acme.com:443":    true,
+		"unverified.com":        false,
+		"foo.whook.dev":         false,
+		"api.whook.dev":         false,
+		"whook.dev":             false,
+		"hooks.example.com":     false,
+		"foo.hooks.example.com": false,
+	} {
+		if err := resolver.VerifiedCustomDomain(context.Background(), host); (err == nil) != want {
+			t.Errorf("VerifiedCustomDomain(%q) = %v, want allowed %v", host, err, want)
+		}
+	}
+}
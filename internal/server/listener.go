@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/whookdev/conductor/internal/config"
+)
+
+// listener is one of the conductor's HTTP servers, each with its own address,
+// timeouts and middleware.
+type listener struct {
+	name   string
+	server *http.Server
+}
+
+func newListener(name string, cfg config.ListenerConfig, handler http.Handler) *listener {
+	return &listener{
+		name: name,
+		server: &http.Server{
+			Addr:         cfg.Addr,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		},
+	}
+}
+
+func (l *listener) serve(logger *slog.Logger) {
+	logger = logger.With("listener", l.name)
+	logger.Info("starting listener",
+		"address", l.server.Addr,
+		"tls", l.server.TLSConfig != nil)
+
+	var err error
+	if l.server.TLSConfig != nil {
+		// Certificates come from TLSConfig.GetCertificate.
+		err = l.server.ListenAndServeTLS("", "")
+	} else {
+		err = l.server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		logger.Error("listener error", "error", err)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/whookdev/conductor/internal/clientip"
+	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/requestid"
+)
+
+// maxProjectBody bounds how much of a request body is read to find the
+// project it names.
+const maxProjectBody = 64 << 10
+
+// middleware wraps a listener's handler. Each listener has its own stack.
+type middleware func(http.Handler) http.Handler
+
+// chain applies mws so the first one sees a request first.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// withRequestID gives every request an ID and returns it to the caller. An
+// ID assigned by a trusted proxy is kept alongside it for correlation.
+func withRequestID(trusted []netip.Prefix) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestid.New()
+			w.Header().Set(requestid.Header, id)
+
+			ctx := requestid.NewContext(r.Context(), id)
+			if proxyID := requestid.FromProxy(r, trusted); proxyID != "" {
+				ctx = requestid.NewCorrelationContext(ctx, proxyID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// rejectUntrustedH2C refuses HTTP/2 without TLS except from the proxies that
+// terminated TLS on our behalf.
+func rejectUntrustedH2C(trusted []netip.Prefix, logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor == 2 && r.TLS == nil && !clientip.Trusted(trusted, r.RemoteAddr) {
+				logger.WarnContext(r.Context(), "h2c request from untrusted address", "remote_addr", r.RemoteAddr)
+				http.Error(w, "HTTP/2 without TLS is only accepted from trusted proxies", http.StatusMisdirectedRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowNetworks answers only peers inside the given networks, so an internal
+// listener stays internal even if its port is exposed by mistake.
+func allowNetworks(allowed []netip.Prefix, logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !clientip.Trusted(allowed, r.RemoteAddr) {
+				logger.WarnContext(r.Context(), "request from outside allowed networks",
+					"remote_addr", r.RemoteAddr,
+					"path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type authenticator interface {
+	Authenticate(ctx context.Context, token string) (*models.APIKey, error)
+}
+
+// requireAPIKey rejects requests without a valid bearer API key and records
+// the key on the request for ownsProject.
+func requireAPIKey(keys authenticator, logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="whook"`)
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+
+			key, err := keys.Authenticate(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				if errors.Is(err, projects.ErrInvalidAPIKey) {
+					logger.WarnContext(r.Context(), "rejected API key",
+						"remote_addr", r.RemoteAddr,
+						"path", r.URL.Path)
+					w.Header().Set("WWW-Authenticate", `Bearer realm="whook", error="invalid_token"`)
+					http.Error(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+				logger.ErrorContext(r.Context(), "unable to authenticate API key", "error", err)
+				http.Error(w, "Unable to authenticate", http.StatusServiceUnavailable)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(projects.NewKeyContext(r.Context(), key)))
+		})
+	}
+}
+
+// ownsProject lets a request through only if the project it acts on is the
+// one its API key belongs to. It wraps individual routes, after the mux has
+// matched them, so project can read path values.
+func ownsProject(project func(*http.Request) (string, error), logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := projects.KeyFromContext(r.Context())
+			if !ok {
+				logger.ErrorContext(r.Context(), "project route without an authenticated key", "path", r.URL.Path)
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+
+			name, err := project(r)
+			if err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			// A missing project is left for the handler to report.
+			if name != "" && name != key.ProjectName {
+				logger.WarnContext(r.Context(), "API key used for another project",
+					"key_id", key.ID,
+					"key_project", key.ProjectName,
+					"project", name)
+				http.Error(w, "API key does not belong to this project", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func pathProject(r *http.Request) (string, error) {
+	return r.PathValue("project"), nil
+}
+
+// bodyProject reads project_name from a JSON body and puts the body back for
+// the handler.
+func bodyProject(r *http.Request) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxProjectBody))
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		ProjectName string `json:"project_name"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", err
+	}
+	return req.ProjectName, nil
+}
@@ -2,57 +2,178 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 	"github.com/whookdev/conductor/internal/conductor"
 	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/dedup"
 	"github.com/whookdev/conductor/internal/handlers"
+	"github.com/whookdev/conductor/internal/metrics"
+	"github.com/whookdev/conductor/internal/projects"
+	"github.com/whookdev/conductor/internal/providers"
+	"github.com/whookdev/conductor/internal/relaytls"
+	"github.com/whookdev/conductor/internal/routing"
+	"github.com/whookdev/conductor/internal/servertls"
 	"github.com/whookdev/conductor/internal/storage"
+	"github.com/whookdev/conductor/pkg/relayauth"
 )
 
 type Server struct {
-	cfg            *config.Config
-	conductor      *conductor.Conductor
-	server         *http.Server
-	logger         *slog.Logger
-	projectHandler *handlers.ProjectHandler
+	cfg             *config.Config
+	conductor       *conductor.Conductor
+	listeners       []*listener
+	api             http.Handler
+	resolver        *routing.Resolver
+	logger          *slog.Logger
+	pipeline        *storage.Pipeline
+	relayTLS        *relaytls.Manager
+	certs           *servertls.Store
+	dispatcher      *handlers.Dispatcher
+	requestStorage  *storage.RequestStorage
+	projectHandler  *handlers.ProjectHandler
+	settingsHandler *handlers.SettingsHandler
+	requestsHandler *handlers.RequestsHandler
+	deadLetters     *handlers.DeadLettersHandler
+	domainsHandler  *handlers.DomainsHandler
+	apiKeys         *projects.KeyStore
+	apiKeysHandler  *handlers.APIKeysHandler
 }
 
-func New(cfg *config.Config, tc *conductor.Conductor, logger *slog.Logger) (*Server, error) {
-	requestStorage := storage.New(logger)
-	projectHandler := handlers.NewProjectHandler(cfg, tc, requestStorage, logger)
+func New(cfg *config.Config, tc *conductor.Conductor, rdb *redis.Client, pool *pgxpool.Pool, logger *slog.Logger) (*Server, error) {
+	store := storage.NewPostgresStore(pool)
+	pipeline, err := storage.NewPipeline(cfg, store, logger)
+	if err != nil {
+		return nil, fmt.Errorf("creating storage pipeline: %w", err)
+	}
+
+	requestStorage := storage.New(pipeline, store, providers.DefaultRegistry(), logger)
+
+	var signer *relayauth.Signer
+	if cfg.RelaySigningKeys != "" {
+		keyring, err := relayauth.ParseKeyring(cfg.RelaySigningKeys, cfg.RelaySigningKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("loading relay signing keys: %w", err)
+		}
+		signer = relayauth.NewSigner(keyring)
+	} else {
+		logger.Warn("RELAY_SIGNING_KEYS not set, requests to relays will not be signed")
+	}
+
+	var tokens *relayauth.TokenSigner
+	if cfg.AssignmentSigningKeys != "" {
+		keyring, err := relayauth.ParseTokenKeyring(cfg.AssignmentSigningKeys, cfg.AssignmentSigningKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("loading assignment signing keys: %w", err)
+		}
+		tokens = relayauth.NewTokenSigner(keyring, cfg.AssignmentTokenTTL)
+	} else {
+		logger.Warn("ASSIGNMENT_SIGNING_KEYS not set, relay assignments will not carry tokens")
+	}
+
+	relayTLS, err := relaytls.New(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("loading relay TLS configuration: %w", err)
+	}
+
+	baseDomains := make([]routing.BaseDomain, 0, len(cfg.BaseDomains))
+	for _, domain := range cfg.BaseDomains {
+		mode, err := routing.ParseMode(domain.RoutingMode)
+		if err != nil {
+			return nil, err
+		}
+		baseDomains = append(baseDomains, routing.BaseDomain{Name: domain.Name, Mode: mode})
+	}
+
+	domainStore := projects.NewDomainStore(cfg, rdb, nil, logger)
+	resolver, err := routing.NewResolver(baseDomains, domainStore)
+	if err != nil {
+		return nil, fmt.Errorf("creating host resolver: %w", err)
+	}
+
+	forwarder := handlers.NewForwarder(cfg, signer, relayTLS, requestStorage, logger)
+	settingsStore := projects.NewSettingsStore(cfg, rdb, logger)
+	dispatcher := handlers.NewDispatcher(cfg, tc, forwarder, settingsStore, logger)
+	deduplicator := dedup.New(cfg, rdb, logger)
+	projectHandler := handlers.NewProjectHandler(cfg, tc, requestStorage, settingsStore, deduplicator, forwarder, dispatcher, tokens, logger)
+	settingsHandler := handlers.NewSettingsHandler(cfg, settingsStore, logger)
+	requestsHandler := handlers.NewRequestsHandler(cfg, requestStorage, logger)
+	deadLetters := handlers.NewDeadLettersHandler(cfg, requestStorage, dispatcher, logger)
+	domainsHandler := handlers.NewDomainsHandler(cfg, domainStore, resolver, logger)
+	apiKeys := projects.NewKeyStore(cfg, pool, logger)
+	apiKeysHandler := handlers.NewAPIKeysHandler(cfg, apiKeys, logger)
 
 	logger = logger.With("component", "server")
 
 	s := &Server{
-		cfg:            cfg,
-		conductor:      tc,
-		logger:         logger,
-		projectHandler: projectHandler,
+		cfg:             cfg,
+		conductor:       tc,
+		logger:          logger,
+		resolver:        resolver,
+		pipeline:        pipeline,
+		relayTLS:        relayTLS,
+		dispatcher:      dispatcher,
+		requestStorage:  requestStorage,
+		projectHandler:  projectHandler,
+		settingsHandler: settingsHandler,
+		requestsHandler: requestsHandler,
+		deadLetters:     deadLetters,
+		domainsHandler:  domainsHandler,
+		apiKeys:         apiKeys,
+		apiKeysHandler:  apiKeysHandler,
 	}
 
-	s.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Handler:      s.routes(),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	s.api = s.apiRoutes()
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2CEnabled)
+
+	public := newListener("public", cfg.PublicListener, chain(s.routes(),
+		withRequestID(cfg.TrustedProxies),
+		rejectUntrustedH2C(cfg.TrustedProxies, logger),
+	))
+	public.server.Protocols = protocols
+	if s.certs, err = newCertStore(cfg, pool, resolver, logger); err != nil {
+		return nil, fmt.Errorf("loading TLS certificates: %w", err)
 	}
+	if s.certs != nil {
+		public.server.TLSConfig = s.certs.TLSConfig()
+	}
+
+	admin := newListener("admin", cfg.AdminListener, chain(s.adminRoutes(),
+		allowNetworks(cfg.AdminAllowedNetworks, logger),
+		withRequestID(cfg.TrustedProxies),
+	))
+
+	metricsListener := newListener("metrics", cfg.MetricsListener, chain(metricsRoutes(),
+		allowNetworks(cfg.AdminAllowedNetworks, logger),
+	))
+
+	s.listeners = []*listener{public, admin, metricsListener}
 
 	return s, nil
 }
 
 func (s *Server) Start(ctx context.Context) error {
-	go func() {
-		s.logger.Info("starting server", "address", s.server.Addr)
-		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
-			s.logger.Error("server error", "error", err)
-		}
-	}()
+	s.pipeline.Start()
+	s.relayTLS.Start(ctx)
+	if s.certs != nil {
+		s.certs.Start(ctx)
+	}
+	// The dispatcher also redrives dead letters, so it runs in sync mode too.
+	s.dispatcher.Start()
+	s.requestStorage.WatchDeadLetters(ctx, 30*time.Second)
+
+	for _, l := range s.listeners {
+		go l.serve(s.logger)
+	}
 
 	<-ctx.Done()
 	return s.Shutdown()
@@ -64,7 +185,52 @@ func (s *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	return s.server.Shutdown(ctx)
+	// Stop accepting webhooks before flushing so nothing is queued after the
+	// pipeline has drained.
+	var serverErr error
+	for _, l := range s.listeners {
+		if err := l.server.Shutdown(ctx); err != nil {
+			serverErr = errors.Join(serverErr, fmt.Errorf("shutting down %s listener: %w", l.name, err))
+		}
+	}
+	s.dispatcher.Close()
+
+	if err := s.requestStorage.Close(ctx); err != nil {
+		s.logger.Error("failed to flush request storage", "error", err)
+		return errors.Join(serverErr, err)
+	}
+
+	return serverErr
+}
+
+// newCertStore builds the public listener's certificate store from whichever
+// sources are configured. It returns nil when TLS isn't configured.
+func newCertStore(cfg *config.Config, pool *pgxpool.Pool, resolver *routing.Resolver, logger *slog.Logger) (*servertls.Store, error) {
+	var sources []servertls.Source
+	if cfg.TLSCertFile != "" {
+		sources = append(sources, &servertls.FileSource{CertFile: cfg.TLSCertFile, KeyFile: cfg.TLSKeyFile})
+	}
+	if cfg.TLSCertDir != "" {
+		sources = append(sources, &servertls.DirSource{Dir: cfg.TLSCertDir})
+	}
+	if cfg.TLSCertsFromPostgres {
+		sources = append(sources, servertls.NewPostgresSource(pool))
+	}
+
+	var issuer servertls.Issuer
+	if cfg.ACMEDirectoryURL != "" {
+		acmeIssuer, err := servertls.NewACMEIssuer(cfg, resolver.VerifiedCustomDomain, logger)
+		if err != nil {
+			return nil, err
+		}
+		issuer = acmeIssuer
+	}
+
+	if len(sources) == 0 && issuer == nil {
+		return nil, nil
+	}
+
+	return servertls.New(cfg, sources, issuer, logger)
 }
 
 func (s *Server) routes() http.Handler {
@@ -75,25 +241,88 @@ func (s *Server) routes() http.Handler {
 	return mux
 }
 
-func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	host := r.Host
-	if !strings.HasSuffix(host, s.cfg.BaseDomain) {
-		s.logger.Warn("request with invalid domain",
-			"host", host,
-			"expected_domain", s.cfg.BaseDomain,
-			"remote_addr", r.RemoteAddr)
-		http.Error(w, "Invalid domain", http.StatusBadRequest)
-		return
-	}
+// apiRoutes are served on the public API host, so only what relay clients
+// and relays need belongs here. Every route but the published keys needs an
+// API key, and one that names a project needs that project's key. API keys
+// themselves are managed on the admin listener, so a leaked key can't be
+// used to mint or rotate others.
+func (s *Server) apiRoutes() http.Handler {
+	public := http.NewServeMux()
+	mux := http.NewServeMux()
+
+	public.HandleFunc("GET /.well-known/jwks.json", s.projectHandler.HandleAssignmentKeys)
+	public.Handle("/", chain(mux, requireAPIKey(s.apiKeys, s.logger)))
+
+	byBody := ownsProject(bodyProject, s.logger)
+	byPath := ownsProject(pathProject, s.logger)
+
+	mux.Handle("POST /relay", byBody(http.HandlerFunc(s.projectHandler.HandleRelayAssignment)))
+	mux.Handle("GET /relay/{project}", byPath(http.HandlerFunc(s.projectHandler.HandleGetRelay)))
+	mux.Handle("GET /relay/{project}/watch", byPath(http.HandlerFunc(s.projectHandler.HandleWatchRelay)))
+	mux.Handle("GET /relay/{project}/history", byPath(http.HandlerFunc(s.projectHandler.HandleAssignmentHistory)))
 
-	if strings.HasPrefix(host, "api.") {
-		if r.Method == http.MethodPost && r.URL.Path == "/relay" {
-			s.projectHandler.HandleRelayAssignment(w, r)
-			return
+	return public
+}
+
+// adminRoutes manage projects and are only served on the admin listener.
+func (s *Server) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /relay", s.projectHandler.HandleRelayAssignment)
+	mux.HandleFunc("GET /relay/{project}", s.projectHandler.HandleGetRelay)
+	mux.HandleFunc("GET /relay/{project}/watch", s.projectHandler.HandleWatchRelay)
+	mux.HandleFunc("GET /relay/{project}/history", s.projectHandler.HandleAssignmentHistory)
+	mux.HandleFunc("GET /.well-known/jwks.json", s.projectHandler.HandleAssignmentKeys)
+	mux.HandleFunc("GET /projects/{project}/settings", s.settingsHandler.HandleGetSettings)
+	mux.HandleFunc("PUT /projects/{project}/settings", s.settingsHandler.HandlePutSettings)
+	mux.HandleFunc("GET /projects/{project}/requests", s.requestsHandler.HandleListRequests)
+	mux.HandleFunc("GET /projects/{project}/requests/{id}", s.requestsHandler.HandleGetRequest)
+	mux.HandleFunc("GET /projects/{project}/dead-letters", s.deadLetters.HandleListDeadLetters)
+	mux.HandleFunc("GET /projects/{project}/dead-letters/{id}", s.deadLetters.HandleGetDeadLetter)
+	mux.HandleFunc("POST /projects/{project}/dead-letters/{id}/redrive", s.deadLetters.HandleRedrive)
+	mux.HandleFunc("DELETE /projects/{project}/dead-letters/{id}", s.deadLetters.HandleDiscard)
+	mux.HandleFunc("GET /projects/{project}/domains", s.domainsHandler.HandleListDomains)
+	mux.HandleFunc("POST /projects/{project}/domains", s.domainsHandler.HandleRegisterDomain)
+	mux.HandleFunc("GET /projects/{project}/domains/{hostname}", s.domainsHandler.HandleGetDomain)
+	mux.HandleFunc("POST /projects/{project}/domains/{hostname}/verify", s.domainsHandler.HandleVerifyDomain)
+	mux.HandleFunc("DELETE /projects/{project}/domains/{hostname}", s.domainsHandler.HandleDeleteDomain)
+	mux.HandleFunc("GET /projects/{project}/api-keys", s.apiKeysHandler.HandleListAPIKeys)
+	mux.HandleFunc("POST /projects/{project}/api-keys", s.apiKeysHandler.HandleCreateAPIKey)
+	mux.HandleFunc("POST /projects/{project}/api-keys/{id}/rotate", s.apiKeysHandler.HandleRotateAPIKey)
+	mux.HandleFunc("DELETE /projects/{project}/api-keys/{id}", s.apiKeysHandler.HandleRevokeAPIKey)
+
+	return mux
+}
+
+func metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	return mux
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	r, route, err := s.resolver.ResolveRequest(r)
+	if err != nil {
+		s.logger.WarnContext(r.Context(), "request for unroutable host",
+			"host", r.Host,
+			"remote_addr", r.RemoteAddr,
+			"error", err)
+		switch {
+		case errors.Is(err, routing.ErrForeignHost):
+			http.Error(w, "Invalid domain", http.StatusBadRequest)
+		case errors.Is(err, routing.ErrInvalidProject), errors.Is(err, routing.ErrReservedName):
+			http.Error(w, "Unknown project", http.StatusNotFound)
+		default:
+			http.Error(w, "Unable to resolve host", http.StatusServiceUnavailable)
 		}
-		http.Error(w, "Not found", http.StatusNotFound)
 		return
-	} else {
+	}
+	switch route.Kind {
+	case routing.KindAPI:
+		s.api.ServeHTTP(w, r)
+	default:
 		s.projectHandler.HandleProjectRequest(w, r)
 	}
 }
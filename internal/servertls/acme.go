@@ -0,0 +1,246 @@
+package servertls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"golang.org/x/crypto/acme"
+)
+
+// HostPolicy decides whether a certificate may be issued for a name, so
+// arbitrary SNI values can't make the conductor request certificates.
+type HostPolicy func(ctx context.Context, hostname string) error
+
+// ACMEIssuer obtains certificates from an ACME directory using the
+// TLS-ALPN-01 challenge, which the public listener answers itself.
+type ACMEIssuer struct {
+	client *acme.Client
+	email  string
+	policy HostPolicy
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	registered bool
+	challenges map[string]*tls.Certificate
+}
+
+func NewACMEIssuer(cfg *config.Config, policy HostPolicy, logger *slog.Logger) (*ACMEIssuer, error) {
+	if policy == nil {
+		return nil, errors.New("ACME issuer requires a host policy")
+	}
+
+	key, err := loadAccountKey(cfg.ACMEAccountKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if cfg.ACMECAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ACMECAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("ACME CA file contains no certificates")
+		}
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		}
+	}
+
+	return &ACMEIssuer{
+		client: &acme.Client{
+			Key:          key,
+			DirectoryURL: cfg.ACMEDirectoryURL,
+			HTTPClient:   httpClient,
+			UserAgent:    "whook-conductor",
+		},
+		email:      cfg.ACMEEmail,
+		policy:     policy,
+		logger:     logger.With("component", "acme_issuer"),
+		challenges: make(map[string]*tls.Certificate),
+	}, nil
+}
+
+func (i *ACMEIssuer) NextProtos() []string {
+	return []string{acme.ALPNProto}
+}
+
+func (i *ACMEIssuer) ChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	cert, ok := i.challenges[name]
+	if !ok {
+		return nil, fmt.Errorf("no ACME challenge pending for %q", name)
+	}
+	return cert, nil
+}
+
+func (i *ACMEIssuer) Issue(ctx context.Context, hostname string) (*tls.Certificate, error) {
+	if err := i.policy(ctx, hostname); err != nil {
+		return nil, fmt.Errorf("refusing to issue certificate for %q: %w", hostname, err)
+	}
+	if err := i.register(ctx); err != nil {
+		return nil, err
+	}
+
+	i.logger.Info("requesting certificate", "name", hostname)
+
+	order, err := i.client.AuthorizeOrder(ctx, acme.DomainIDs(hostname))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := i.authorize(ctx, url, hostname); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = i.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{hostname},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	der, _, err := i.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// authorize completes one authorization with the TLS-ALPN-01 challenge,
+// serving its certificate until the CA has checked it.
+func (i *ACMEIssuer) authorize(ctx context.Context, url, hostname string) error {
+	authz, err := i.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "tls-alpn-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offered no tls-alpn-01 challenge for %q", hostname)
+	}
+
+	cert, err := i.client.TLSALPN01ChallengeCert(chal.Token, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to create challenge certificate: %w", err)
+	}
+
+	i.mu.Lock()
+	i.challenges[hostname] = &cert
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		delete(i.challenges, hostname)
+		i.mu.Unlock()
+	}()
+
+	if _, err := i.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+	if _, err := i.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization for %q failed: %w", hostname, err)
+	}
+	return nil
+}
+
+func (i *ACMEIssuer) register(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.registered {
+		return nil
+	}
+
+	account := &acme.Account{}
+	if i.email != "" {
+		account.Contact = []string{"mailto:" + i.email}
+	}
+	if _, err := i.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	i.registered = true
+	return nil
+}
+
+// loadAccountKey reads the ACME account key, creating it if the file doesn't
+// exist yet. Without a file the key only lasts as long as the process.
+func loadAccountKey(path string) (crypto.Signer, error) {
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			block, _ := pem.Decode(raw)
+			if block == nil {
+				return nil, fmt.Errorf("ACME account key %s is not PEM", path)
+			}
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing ACME account key: %w", err)
+			}
+			return key, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading ACME account key: %w", err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+
+	if path != "" {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode ACME account key: %w", err)
+		}
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
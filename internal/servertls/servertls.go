@@ -0,0 +1,370 @@
+// Package servertls terminates TLS on the public listener, choosing a
+// certificate by SNI from certificates loaded from files, a directory or
+// Postgres, and optionally issuing missing ones through ACME.
+package servertls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+)
+
+const (
+	// renewBefore is how long before expiry an issued certificate is
+	// replaced.
+	renewBefore = 30 * 24 * time.Hour
+	// issueBackoff stops a failing name from being sent to the CA on every
+	// handshake.
+	issueBackoff = 10 * time.Minute
+	issueTimeout = 2 * time.Minute
+	// maxFailedNames bounds the names held in backoff, should many different
+	// names fail at once.
+	maxFailedNames = 10000
+)
+
+// Issuer obtains certificates for names no source has a certificate for.
+type Issuer interface {
+	Issue(ctx context.Context, hostname string) (*tls.Certificate, error)
+	// ChallengeCertificate answers the CA's validation handshake for a name
+	// being issued.
+	ChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	// NextProtos are the ALPN protocols the validation handshake uses.
+	NextProtos() []string
+}
+
+type issueCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// Store holds the public listener's certificates, indexed by the names they
+// cover, and reloads them from its sources every TLSReloadInterval.
+type Store struct {
+	cfg     *config.Config
+	sources []Source
+	issuer  Issuer
+	logger  *slog.Logger
+
+	mu       sync.RWMutex
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	issued   []*tls.Certificate
+	issuing  map[string]*issueCall
+	failed   map[string]time.Time
+}
+
+// New loads the sources once, failing if nothing usable was found and there
+// is no issuer to fill the gap. issuer may be nil.
+func New(cfg *config.Config, sources []Source, issuer Issuer, logger *slog.Logger) (*Store, error) {
+	if cfg.TLSReloadInterval <= 0 {
+		return nil, errors.New("TLS reload interval must be positive")
+	}
+
+	s := &Store{
+		cfg:     cfg,
+		sources: sources,
+		issuer:  issuer,
+		logger:  logger.With("component", "server_tls"),
+		byName:  make(map[string]*tls.Certificate),
+		issuing: make(map[string]*issueCall),
+		failed:  make(map[string]time.Time),
+	}
+
+	count, err := s.reload(context.Background())
+	if err != nil {
+		if count == 0 && issuer == nil {
+			return nil, err
+		}
+		s.logger.Warn("some certificates could not be loaded", "error", err)
+	}
+	if count == 0 && issuer == nil {
+		return nil, errors.New("no TLS certificates found")
+	}
+
+	return s, nil
+}
+
+// Start reloads the sources periodically so new and rotated certificates
+// are served without a restart.
+func (s *Store) Start(ctx context.Context) {
+	s.logger.Info("watching TLS certificates",
+		"interval", s.cfg.TLSReloadInterval,
+		"sources", len(s.sources),
+		"acme", s.issuer != nil)
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.TLSReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.reload(ctx); err != nil {
+					s.logger.Error("failed to reload TLS certificates", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// TLSConfig returns the listener's TLS configuration.
+func (s *Store) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     s.cfg.TLSMinVersion,
+		GetCertificate: s.GetCertificate,
+	}
+	if s.issuer != nil {
+		cfg.NextProtos = s.issuer.NextProtos()
+	}
+	return cfg
+}
+
+// GetCertificate picks the certificate for a handshake: an exact match for
+// the server name, then a wildcard, then one from the issuer. Clients that
+// send no server name get the first certificate loaded.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.issuer != nil {
+		for _, proto := range s.issuer.NextProtos() {
+			if slices.Contains(hello.SupportedProtos, proto) {
+				return s.issuer.ChallengeCertificate(hello)
+			}
+		}
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	cert := s.lookup(name)
+	fallback := s.fallback
+	s.mu.RUnlock()
+
+	if name == "" {
+		if fallback == nil {
+			return nil, errors.New("no certificate for clients without SNI")
+		}
+		return fallback, nil
+	}
+
+	if s.issuer == nil {
+		if cert == nil {
+			return nil, fmt.Errorf("no certificate for %q", name)
+		}
+		return cert, nil
+	}
+
+	if cert != nil {
+		if time.Until(cert.Leaf.NotAfter) < renewBefore {
+			go s.issue(context.Background(), name)
+		}
+		return cert, nil
+	}
+
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return s.issue(ctx, name)
+}
+
+// lookup must be called with mu held.
+func (s *Store) lookup(name string) *tls.Certificate {
+	if cert, ok := s.byName[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return s.byName["*"+name[i:]]
+	}
+	return nil
+}
+
+// issue obtains a certificate for name, sharing the result with any
+// handshakes already waiting on the same name.
+func (s *Store) issue(ctx context.Context, name string) (*tls.Certificate, error) {
+	s.mu.Lock()
+	if call, ok := s.issuing[name]; ok {
+		s.mu.Unlock()
+		<-call.done
+		return call.cert, call.err
+	}
+	if at, ok := s.failed[name]; ok && time.Since(at) < issueBackoff {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("no certificate for %q; issuing failed recently", name)
+	}
+	call := &issueCall{done: make(chan struct{})}
+	s.issuing[name] = call
+	s.mu.Unlock()
+
+	// The CA can take longer than the client that triggered issuance is
+	// willing to wait, and the certificate is worth having either way.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), issueTimeout)
+	defer cancel()
+
+	call.cert, call.err = s.issuer.Issue(ctx, name)
+
+	s.mu.Lock()
+	delete(s.issuing, name)
+	if call.err != nil {
+		s.recordFailure(name, time.Now())
+	} else {
+		delete(s.failed, name)
+		s.issued = append(s.issued, call.cert)
+		s.index(call.cert)
+	}
+	s.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		s.logger.Error("failed to issue certificate", "name", name, "error", call.err)
+		return nil, call.err
+	}
+
+	s.logger.Info("issued certificate", "name", name, "not_after", call.cert.Leaf.NotAfter)
+	s.save(ctx, name, call.cert)
+
+	return call.cert, nil
+}
+
+// recordFailure puts name in backoff, first dropping the names whose backoff
+// has passed so the map only holds names that failed recently. It must be
+// called with mu held.
+func (s *Store) recordFailure(name string, now time.Time) {
+	for failed, at := range s.failed {
+		if now.Sub(at) >= issueBackoff {
+			delete(s.failed, failed)
+		}
+	}
+	if len(s.failed) >= maxFailedNames {
+		for failed := range s.failed {
+			delete(s.failed, failed)
+			break
+		}
+	}
+	s.failed[name] = now
+}
+
+// save hands an issued certificate to the first source that can keep it.
+func (s *Store) save(ctx context.Context, name string, cert *tls.Certificate) {
+	for _, source := range s.sources {
+		saver, ok := source.(Saver)
+		if !ok {
+			continue
+		}
+
+		certPEM, keyPEM, err := encodePEM(cert)
+		if err == nil {
+			err = saver.Save(ctx, name, certPEM, keyPEM)
+		}
+		if err != nil {
+			s.logger.Error("failed to save issued certificate",
+				"name", name,
+				"source", source.Name(),
+				"error", err)
+		}
+		return
+	}
+}
+
+// reload reads every source and swaps in the new index. A source that fails
+// entirely keeps nothing from it, so the previous index is kept if nothing
+// at all could be loaded. It returns the number of certificates indexed.
+func (s *Store) reload(ctx context.Context) (int, error) {
+	var (
+		certs []*tls.Certificate
+		errs  []error
+	)
+	for _, source := range s.sources {
+		loaded, err := source.Load(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name(), err))
+		}
+		certs = append(certs, loaded...)
+	}
+	err := errors.Join(errs...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(certs) == 0 && err != nil {
+		return len(s.byName), err
+	}
+
+	s.byName = make(map[string]*tls.Certificate)
+	s.fallback = nil
+	for _, cert := range certs {
+		s.index(cert)
+	}
+	if len(certs) > 0 {
+		s.fallback = certs[0]
+	}
+
+	// Issued certificates stay until a source has the same names covered
+	// for at least as long.
+	kept := s.issued[:0]
+	for _, cert := range s.issued {
+		if s.index(cert) {
+			kept = append(kept, cert)
+		}
+	}
+	s.issued = kept
+
+	return len(certs), err
+}
+
+// index adds cert under every name it covers, unless a certificate that
+// lasts longer is already there. It reports whether cert was used for any
+// name. It must be called with mu held.
+func (s *Store) index(cert *tls.Certificate) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			s.logger.Error("failed to parse certificate", "error", err)
+			return false
+		}
+		cert.Leaf = leaf
+	}
+
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+
+	used := false
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if existing, ok := s.byName[name]; ok && !leaf.NotAfter.After(existing.Leaf.NotAfter) {
+			continue
+		}
+		s.byName[name] = cert
+		used = true
+	}
+	return used
+}
+
+func encodePEM(cert *tls.Certificate) ([]byte, []byte, error) {
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	return certPEM, keyPEM, nil
+}
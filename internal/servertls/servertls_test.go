@@ -0,0 +1,340 @@
+package servertls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+)
+
+// memorySource serves whatever certificates it currently holds, so a test
+// can rotate them and reload.
+type memorySource struct {
+	mu    sync.Mutex
+	certs []*tls.Certificate
+	err   error
+}
+
+func (s *memorySource) Name() string {
+	return "memory"
+}
+
+func (s *memorySource) Load(context.Context) ([]*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certs, s.err
+}
+
+func (s *memorySource) set(err error, certs ...*tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs, s.err = certs, err
+}
+
+// stubIssuer issues a fresh self-signed certificate for any name, or fails
+// for the names in fail, and counts how often each name was asked for.
+type stubIssuer struct {
+	t       *testing.T
+	fail    map[string]bool
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	return &stubIssuer{t: t, fail: make(map[string]bool), calls: make(map[string]int)}
+}
+
+func (i *stubIssuer) Issue(_ context.Context, hostname string) (*tls.Certificate, error) {
+	i.mu.Lock()
+	i.calls[hostname]++
+	i.mu.Unlock()
+
+	if i.release != nil {
+		<-i.release
+	}
+	if i.fail[hostname] {
+		return nil, errors.New("CA said no")
+	}
+	return newCert(i.t, 90*24*time.Hour, hostname), nil
+}
+
+func (i *stubIssuer) ChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return challengeCert, nil
+}
+
+func (i *stubIssuer) NextProtos() []string {
+	return []string{"acme-tls/1"}
+}
+
+func (i *stubIssuer) callsFor(hostname string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.calls[hostname]
+}
+
+var challengeCert = &tls.Certificate{}
+
+func newCert(t *testing.T, validFor time.Duration, names ...string) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestStore(t *testing.T, source *memorySource, issuer Issuer) *Store {
+	t.Helper()
+
+	cfg := &config.Config{TLSReloadInterval: time.Minute}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s, err := New(cfg, []Source{source}, issuer, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGetCertificate(t *testing.T) {
+	exact := newCert(t, 365*24*time.Hour, "foo.example.com")
+	wildcard := newCert(t, 365*24*time.Hour, "*.example.com", "example.com")
+	source := &memorySource{}
+	source.set(nil, exact, wildcard)
+	s := newTestStore(t, source, nil)
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"foo.example.com", exact},
+		{"FOO.Example.COM.", exact},
+		{"bar.example.com", wildcard},
+		{"example.com", wildcard},
+		{"", exact},
+		{"a.bar.example.com", nil},
+		{"example.org", nil},
+	}
+	for _, tt := range tests {
+		// Handshakes built by hand have no context, which must not matter.
+		got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("GetCertificate(%q) returned a certificate for %v", tt.serverName, got.Leaf.DNSNames)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("GetCertificate(%q) = %v, %v; want the certificate for %v", tt.serverName, got, err, tt.want.Leaf.DNSNames)
+		}
+	}
+}
+
+func TestGetCertificateLongerLivedWins(t *testing.T) {
+	short := newCert(t, 60*24*time.Hour, "foo.example.com")
+	long := newCert(t, 365*24*time.Hour, "foo.example.com")
+	source := &memorySource{}
+	source.set(nil, long, short)
+	s := newTestStore(t, source, nil)
+
+	if got, _ := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); got != long {
+		t.Fatal("served the certificate that expires sooner")
+	}
+}
+
+func TestGetCertificateReload(t *testing.T) {
+	ctx := context.Background()
+	old := newCert(t, 365*24*time.Hour, "foo.example.com")
+	source := &memorySource{}
+	source.set(nil, old)
+	s := newTestStore(t, source, nil)
+
+	rotated := newCert(t, 365*24*time.Hour, "foo.example.com", "bar.example.com")
+	source.set(nil, rotated)
+	if _, err := s.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo.example.com", "bar.example.com", ""} {
+		if got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err != nil || got != rotated {
+			t.Fatalf("after reload GetCertificate(%q) = %v, %v; want the rotated certificate", name, got, err)
+		}
+	}
+
+	// A source that fails outright leaves the last good certificates in
+	// place rather than serving nothing.
+	source.set(errors.New("disk on fire"))
+	if _, err := s.reload(ctx); err == nil {
+		t.Fatal("reload of a failing source returned no error")
+	}
+	if got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "bar.example.com"}); err != nil || got != rotated {
+		t.Fatalf("after a failed reload GetCertificate = %v, %v; want the rotated certificate", got, err)
+	}
+
+	// Names dropped by a successful reload stop being served.
+	source.set(nil, old)
+	if _, err := s.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "bar.example.com"}); err == nil {
+		t.Fatal("still serving a name no source has any more")
+	}
+}
+
+func TestGetCertificateIssues(t *testing.T) {
+	wildcard := newCert(t, 365*24*time.Hour, "*.example.com")
+	source := &memorySource{}
+	source.set(nil, wildcard)
+	issuer := newStubIssuer(t)
+	issuer.release = make(chan struct{})
+	s := newTestStore(t, source, issuer)
+
+	// Names a source covers never reach the issuer.
+	if got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.example.com"}); err != nil || got != wildcard {
+		t.Fatalf("GetCertificate under the wildcard = %v, %v", got, err)
+	}
+
+	// Concurrent handshakes for a missing name share one issuance.
+	const handshakes = 5
+	results := make(chan *tls.Certificate, handshakes)
+	for range handshakes {
+		go func() {
+			cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"})
+			if err != nil {
+				t.Error(err)
+			}
+			results <- cert
+		}()
+	}
+	for issuer.callsFor("hooks.acme.com") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(issuer.release)
+
+	var first *tls.Certificate
+	for range handshakes {
+		cert := <-results
+		if first == nil {
+			first = cert
+		}
+		if cert == nil || cert != first {
+			t.Fatal("concurrent handshakes got different certificates")
+		}
+	}
+	if n := issuer.callsFor("hooks.acme.com"); n != 1 {
+		t.Fatalf("issuer called %d times, want 1", n)
+	}
+
+	// The issued certificate is served from then on, and survives a reload
+	// of sources that don't have it.
+	if _, err := s.reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"}); err != nil || got != first {
+		t.Fatalf("after issuing GetCertificate = %v, %v", got, err)
+	}
+	if n := issuer.callsFor("hooks.acme.com"); n != 1 {
+		t.Fatalf("issuer called %d times, want 1", n)
+	}
+}
+
+func TestGetCertificateIssueBackoff(t *testing.T) {
+	source := &memorySource{}
+	issuer := newStubIssuer(t)
+	issuer.fail["hooks.acme.com"] = true
+	s := newTestStore(t, source, issuer)
+
+	for range 3 {
+		if _, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"}); err == nil {
+			t.Fatal("GetCertificate succeeded for a name the issuer refuses")
+		}
+	}
+	if n := issuer.callsFor("hooks.acme.com"); n != 1 {
+		t.Fatalf("issuer called %d times during backoff, want 1", n)
+	}
+
+	// Once the backoff has passed the name is tried again, and the old
+	// failure is swept when the next one is recorded.
+	s.mu.Lock()
+	s.failed["hooks.acme.com"] = time.Now().Add(-issueBackoff)
+	s.mu.Unlock()
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"}); err == nil {
+		t.Fatal("GetCertificate succeeded for a name the issuer refuses")
+	}
+	if n := issuer.callsFor("hooks.acme.com"); n != 2 {
+		t.Fatalf("issuer called %d times after backoff, want 2", n)
+	}
+
+	s.mu.Lock()
+	s.failed["stale.example"] = time.Now().Add(-issueBackoff)
+	s.recordFailure("other.example", time.Now())
+	_, stale := s.failed["stale.example"]
+	s.mu.Unlock()
+	if stale {
+		t.Fatal("a failure past its backoff was not swept")
+	}
+}
+
+func TestGetCertificateRenews(t *testing.T) {
+	expiring := newCert(t, 7*24*time.Hour, "hooks.acme.com")
+	source := &memorySource{}
+	source.set(nil, expiring)
+	issuer := newStubIssuer(t)
+	s := newTestStore(t, source, issuer)
+
+	// The expiring certificate is served while a new one is issued.
+	if got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"}); err != nil || got != expiring {
+		t.Fatalf("GetCertificate = %v, %v; want the expiring certificate", got, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "hooks.acme.com"})
+		if err == nil && got != expiring {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expiring certificate was never renewed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetCertificateChallenge(t *testing.T) {
+	source := &memorySource{}
+	source.set(nil, newCert(t, 365*24*time.Hour, "hooks.acme.com"))
+	s := newTestStore(t, source, newStubIssuer(t))
+
+	got, err := s.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:      "hooks.acme.com",
+		SupportedProtos: []string{"acme-tls/1"},
+	})
+	if err != nil || got != challengeCert {
+		t.Fatalf("validation handshake got %v, %v; want the challenge certificate", got, err)
+	}
+}
@@ -0,0 +1,174 @@
+package servertls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Source supplies certificates for the store. Sources are read again on
+// every reload, so rotated certificates are picked up without a restart.
+type Source interface {
+	Name() string
+	Load(ctx context.Context) ([]*tls.Certificate, error)
+}
+
+// Saver is a source that can also keep certificates the ACME issuer
+// obtained, so they survive restarts and are shared between instances.
+type Saver interface {
+	Save(ctx context.Context, name string, certPEM, keyPEM []byte) error
+}
+
+// FileSource is a single certificate and key, as configured by
+// TLS_CERT_FILE and TLS_KEY_FILE.
+type FileSource struct {
+	CertFile string
+	KeyFile  string
+}
+
+func (s *FileSource) Name() string {
+	return "file"
+}
+
+func (s *FileSource) Load(context.Context) ([]*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate %s: %w", s.CertFile, err)
+	}
+	return []*tls.Certificate{&cert}, nil
+}
+
+// DirSource loads every <name>.crt with a matching <name>.key from a
+// directory.
+type DirSource struct {
+	Dir string
+}
+
+func (s *DirSource) Name() string {
+	return "directory"
+}
+
+func (s *DirSource) Load(context.Context) ([]*tls.Certificate, error) {
+	certFiles, err := filepath.Glob(filepath.Join(s.Dir, "*.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("listing certificates in %s: %w", s.Dir, err)
+	}
+
+	var (
+		certs []*tls.Certificate
+		errs  []error
+	)
+	for _, certFile := range certFiles {
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			// One bad pair shouldn't take every other certificate down.
+			errs = append(errs, fmt.Errorf("loading certificate %s: %w", certFile, err))
+			continue
+		}
+		certs = append(certs, &cert)
+	}
+
+	return certs, errors.Join(errs...)
+}
+
+func (s *DirSource) Save(_ context.Context, name string, certPEM, keyPEM []byte) error {
+	base := filepath.Join(s.Dir, fileName(name))
+
+	// The key goes first so a reload never sees a certificate without one.
+	if err := writeFileAtomic(base+".key", keyPEM, 0o600); err != nil {
+		return err
+	}
+	return writeFileAtomic(base+".crt", certPEM, 0o644)
+}
+
+// fileName makes a certificate name safe to use as a file name; wildcards
+// become "_wildcard".
+func fileName(name string) string {
+	name = strings.ReplaceAll(name, "*", "_wildcard")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(name))
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("renaming %s: %w", tmp, err)
+	}
+	return nil
+}
+
+const (
+	selectCertificatesSQL = `SELECT name, cert_pem, key_pem FROM tls_certificates`
+
+	upsertCertificateSQL = `
+INSERT INTO tls_certificates (name, cert_pem, key_pem, updated_at)
+VALUES ($1, $2, $3, now())
+ON CONFLICT (name) DO UPDATE
+SET cert_pem = EXCLUDED.cert_pem, key_pem = EXCLUDED.key_pem, updated_at = now()`
+)
+
+// PostgresSource loads certificates from the tls_certificates table, which
+// every conductor instance shares.
+type PostgresSource struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresSource(pool *pgxpool.Pool) *PostgresSource {
+	return &PostgresSource{pool: pool}
+}
+
+func (s *PostgresSource) Name() string {
+	return "postgres"
+}
+
+func (s *PostgresSource) Load(ctx context.Context) ([]*tls.Certificate, error) {
+	rows, err := s.pool.Query(ctx, selectCertificatesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		certs []*tls.Certificate
+		errs  []error
+	)
+	for rows.Next() {
+		var name, certPEM, keyPEM string
+		if err := rows.Scan(&name, &certPEM, &keyPEM); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate: %w", err)
+		}
+
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing certificate %s: %w", name, err))
+			continue
+		}
+		certs = append(certs, &cert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %w", err)
+	}
+
+	return certs, errors.Join(errs...)
+}
+
+func (s *PostgresSource) Save(ctx context.Context, name string, certPEM, keyPEM []byte) error {
+	if _, err := s.pool.Exec(ctx, upsertCertificateSQL, name, string(certPEM), string(keyPEM)); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
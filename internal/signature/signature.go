@@ -0,0 +1,317 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+const (
+	SchemeGitHub           = "github"
+	SchemeStripe           = "stripe"
+	SchemeSlack            = "slack"
+	SchemeStandardWebhooks = "standard_webhooks"
+	SchemeSvix             = "svix"
+	SchemeHMAC             = "hmac"
+)
+
+// DefaultTolerance is how far a signed timestamp may drift from the
+// conductor's clock before the request is treated as a replay. MaxTolerance
+// caps what a project may configure; beyond it the timestamp no longer
+// guards against replays.
+const (
+	DefaultTolerance = 5 * time.Minute
+	MaxTolerance     = 24 * time.Hour
+)
+
+var (
+	ErrMissingSignature = errors.New("signature missing")
+	ErrInvalidSignature = errors.New("signature does not match")
+	ErrTimestampExpired = errors.New("signature timestamp outside tolerance")
+)
+
+type Result string
+
+const (
+	ResultValid   Result = "valid"
+	ResultInvalid Result = "invalid"
+	ResultMissing Result = "missing"
+	ResultExpired Result = "expired"
+)
+
+// ResultFor maps the error returned by a Verifier onto the result reported to
+// relays and recorded against the stored request.
+func ResultFor(err error) Result {
+	switch {
+	case err == nil:
+		return ResultValid
+	case errors.Is(err, ErrMissingSignature):
+		return ResultMissing
+	case errors.Is(err, ErrTimestampExpired):
+		return ResultExpired
+	default:
+		return ResultInvalid
+	}
+}
+
+type Verifier interface {
+	Verify(header http.Header, body []byte, now time.Time) error
+}
+
+func New(settings *models.VerificationSettings) (Verifier, error) {
+	if settings.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+
+	tolerance := settings.Tolerance(DefaultTolerance)
+
+	switch settings.Scheme {
+	case SchemeGitHub:
+		return &githubVerifier{secret: []byte(settings.Secret)}, nil
+
+	case SchemeStripe:
+		return &stripeVerifier{secret: []byte(settings.Secret), tolerance: tolerance}, nil
+
+	case SchemeSlack:
+		return &slackVerifier{secret: []byte(settings.Secret), tolerance: tolerance}, nil
+
+	case SchemeStandardWebhooks, SchemeSvix:
+		key, err := decodeStandardSecret(settings.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return &standardVerifier{key: key, tolerance: tolerance}, nil
+
+	case SchemeHMAC:
+		return newHMACVerifier(settings)
+
+	default:
+		return nil, fmt.Errorf("unknown verification scheme %q", settings.Scheme)
+	}
+}
+
+type githubVerifier struct {
+	secret []byte
+}
+
+func (v *githubVerifier) Verify(header http.Header, body []byte, now time.Time) error {
+	sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok || sig == "" {
+		return ErrMissingSignature
+	}
+
+	return compareHex(sig, computeHMAC(sha256.New, v.secret, body))
+}
+
+type stripeVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+}
+
+func (v *stripeVerifier) Verify(header http.Header, body []byte, now time.Time) error {
+	raw := header.Get("Stripe-Signature")
+	if raw == "" {
+		return ErrMissingSignature
+	}
+
+	var (
+		timestamp  string
+		signatures []string
+	)
+	for _, part := range strings.Split(raw, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, now, v.tolerance); err != nil {
+		return err
+	}
+
+	expected := computeHMAC(sha256.New, v.secret, []byte(timestamp), []byte("."), body)
+	for _, sig := range signatures {
+		if compareHex(sig, expected) == nil {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+type slackVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+}
+
+func (v *slackVerifier) Verify(header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	sig, ok := strings.CutPrefix(header.Get("X-Slack-Signature"), "v0=")
+	if timestamp == "" || !ok || sig == "" {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, now, v.tolerance); err != nil {
+		return err
+	}
+
+	return compareHex(sig, computeHMAC(sha256.New, v.secret, []byte("v0:"+timestamp+":"), body))
+}
+
+// standardVerifier implements the Standard Webhooks spec. Svix predates the
+// spec and sends the same values under svix- prefixed headers.
+type standardVerifier struct {
+	key       []byte
+	tolerance time.Duration
+}
+
+func (v *standardVerifier) Verify(header http.Header, body []byte, now time.Time) error {
+	id, timestamp, sigs := header.Get("Webhook-Id"), header.Get("Webhook-Timestamp"), header.Get("Webhook-Signature")
+	if id == "" {
+		id, timestamp, sigs = header.Get("Svix-Id"), header.Get("Svix-Timestamp"), header.Get("Svix-Signature")
+	}
+	if id == "" || timestamp == "" || sigs == "" {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, now, v.tolerance); err != nil {
+		return err
+	}
+
+	expected := computeHMAC(sha256.New, v.key, []byte(id+"."+timestamp+"."), body)
+	for _, sig := range strings.Fields(sigs) {
+		version, value, ok := strings.Cut(sig, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		if hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func decodeStandardSecret(secret string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return nil, fmt.Errorf("secret must be base64 encoded: %w", err)
+	}
+	return key, nil
+}
+
+type hmacVerifier struct {
+	secret   []byte
+	header   string
+	hash     func() hash.Hash
+	encoding string
+	prefix   string
+}
+
+func newHMACVerifier(settings *models.VerificationSettings) (*hmacVerifier, error) {
+	if settings.Header == "" {
+		return nil, errors.New("header is required for the hmac scheme")
+	}
+
+	v := &hmacVerifier{
+		secret:   []byte(settings.Secret),
+		header:   settings.Header,
+		encoding: settings.Encoding,
+		prefix:   settings.Prefix,
+	}
+
+	switch settings.Algorithm {
+	case "", "sha256":
+		v.hash = sha256.New
+	case "sha1":
+		v.hash = sha1.New
+	case "sha512":
+		v.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported hmac algorithm %q", settings.Algorithm)
+	}
+
+	switch v.encoding {
+	case "":
+		v.encoding = "hex"
+	case "hex", "base64":
+	default:
+		return nil, fmt.Errorf("unsupported signature encoding %q", settings.Encoding)
+	}
+
+	return v, nil
+}
+
+func (v *hmacVerifier) Verify(header http.Header, body []byte, now time.Time) error {
+	sig, ok := strings.CutPrefix(header.Get(v.header), v.prefix)
+	if !ok || sig == "" {
+		return ErrMissingSignature
+	}
+
+	expected := computeHMAC(v.hash, v.secret, body)
+
+	if v.encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil || !hmac.Equal(decoded, expected) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	return compareHex(sig, expected)
+}
+
+func computeHMAC(h func() hash.Hash, key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(h, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+func compareHex(sig string, expected []byte) error {
+	decoded, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(decoded, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func checkTimestamp(raw string, now time.Time, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	drift := now.Sub(time.Unix(seconds, 0))
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > tolerance {
+		return ErrTimestampExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package signature
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// Known-good vectors, taken from each provider's documentation where it
+// publishes one and checked against an independent HMAC implementation.
+const (
+	// GitHub's "Validating webhook deliveries" example.
+	githubSecret    = "It's a Secret to Everybody"
+	githubBody      = "Hello, World!"
+	githubSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+	// Slack's "Verifying requests from Slack" example.
+	slackSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	slackTimestamp = "1531420618"
+	slackBody      = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	slackSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+
+	// The Standard Webhooks / Svix documentation example.
+	standardSecret    = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	standardID        = "msg_p5jXN8AQM9LWM0D4loKWxJek"
+	standardTimestamp = "1614265330"
+	standardBody      = `{"test": 2432232314}`
+	standardSignature = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+
+	// Stripe publishes no vector, so this one was computed independently.
+	stripeSecret    = "whsec_test_secret"
+	stripeTimestamp = "1700000000"
+	stripeBody      = `{"id":"evt_1","object":"event"}`
+	stripeSignature = "0c8670ed117751cc551a20e35839447075c42800ea3cf3e8a2fbda99cd1e6edd"
+
+	// RFC 4231 test case 2, and RFC 2202 for SHA-1.
+	hmacSecret = "Jefe"
+	hmacBody   = "what do ya want for nothing?"
+	hmacSHA1   = "effcdf6ae5eb2fa2d27416d5f184df9c259a7c79"
+	hmacSHA256 = "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	hmacSHA512 = "164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea2505549758bf75c05a994a6d034f65f8f0e6fdcaeab1a34d4a6b4b636e070a38bce737"
+	hmacBase64 = "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM="
+)
+
+func headers(kv ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Add(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func unix(raw string) time.Time {
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return time.Unix(seconds, 0)
+}
+
+func TestVerify(t *testing.T) {
+	const tolerance = 300 * time.Second
+
+	github := &models.VerificationSettings{Scheme: SchemeGitHub, Secret: githubSecret}
+	stripe := &models.VerificationSettings{Scheme: SchemeStripe, Secret: stripeSecret, ToleranceSeconds: 300}
+	slack := &models.VerificationSettings{Scheme: SchemeSlack, Secret: slackSecret, ToleranceSeconds: 300}
+	standard := &models.VerificationSettings{Scheme: SchemeStandardWebhooks, Secret: standardSecret, ToleranceSeconds: 300}
+	svix := &models.VerificationSettings{Scheme: SchemeSvix, Secret: standardSecret, ToleranceSeconds: 300}
+	hmacHex := &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Prefix: "sha256="}
+
+	stripeNow := unix(stripeTimestamp)
+	slackNow := unix(slackTimestamp)
+	standardNow := unix(standardTimestamp)
+
+	standardHeaders := func(sig string) http.Header {
+		return headers("Webhook-Id", standardID, "Webhook-Timestamp", standardTimestamp, "Webhook-Signature", sig)
+	}
+
+	tests := []struct {
+		name     string
+		settings *models.VerificationSettings
+		header   http.Header
+		body     string
+		now      time.Time
+		want     error
+	}{
+		// GitHub
+		{"github valid", github, headers("X-Hub-Signature-256", githubSignature), githubBody, time.Now(), nil},
+		{"github tampered body", github, headers("X-Hub-Signature-256", githubSignature), githubBody + "!", time.Now(), ErrInvalidSignature},
+		{"github wrong secret", &models.VerificationSettings{Scheme: SchemeGitHub, Secret: "nope"}, headers("X-Hub-Signature-256", githubSignature), githubBody, time.Now(), ErrInvalidSignature},
+		{"github missing", github, headers(), githubBody, time.Now(), ErrMissingSignature},
+		{"github sha1 only", github, headers("X-Hub-Signature", "sha1="+hmacSHA1), githubBody, time.Now(), ErrMissingSignature},
+		{"github no prefix", github, headers("X-Hub-Signature-256", githubSignature[len("sha256="):]), githubBody, time.Now(), ErrMissingSignature},
+		{"github not hex", github, headers("X-Hub-Signature-256", "sha256=zz"), githubBody, time.Now(), ErrInvalidSignature},
+		{"github truncated", github, headers("X-Hub-Signature-256", githubSignature[:20]), githubBody, time.Now(), ErrInvalidSignature},
+
+		// Stripe
+		{"stripe valid", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody, stripeNow, nil},
+		{"stripe spaces and v0", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+", v0=abcd, v1="+stripeSignature), stripeBody, stripeNow, nil},
+		{"stripe second of several", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+hmacSHA256+",v1="+stripeSignature), stripeBody, stripeNow, nil},
+		{"stripe only bad signatures", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+hmacSHA256+",v1=zz"), stripeBody, stripeNow, ErrInvalidSignature},
+		{"stripe tampered body", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody + " ", stripeNow, ErrInvalidSignature},
+		{"stripe tampered timestamp", stripe, headers("Stripe-Signature", "t=1700000001,v1="+stripeSignature), stripeBody, stripeNow, ErrInvalidSignature},
+		{"stripe missing", stripe, headers(), stripeBody, stripeNow, ErrMissingSignature},
+		{"stripe no timestamp", stripe, headers("Stripe-Signature", "v1="+stripeSignature), stripeBody, stripeNow, ErrMissingSignature},
+		{"stripe no v1", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v0="+stripeSignature), stripeBody, stripeNow, ErrMissingSignature},
+		{"stripe garbage", stripe, headers("Stripe-Signature", "garbage"), stripeBody, stripeNow, ErrMissingSignature},
+		{"stripe non-numeric timestamp", stripe, headers("Stripe-Signature", "t=soon,v1="+stripeSignature), stripeBody, stripeNow, ErrInvalidSignature},
+		{"stripe just inside tolerance", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody, stripeNow.Add(tolerance), nil},
+		{"stripe just outside tolerance", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody, stripeNow.Add(tolerance + time.Second), ErrTimestampExpired},
+		{"stripe from the future", stripe, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody, stripeNow.Add(-tolerance - time.Second), ErrTimestampExpired},
+		{"stripe default tolerance", &models.VerificationSettings{Scheme: SchemeStripe, Secret: stripeSecret}, headers("Stripe-Signature", "t="+stripeTimestamp+",v1="+stripeSignature), stripeBody, stripeNow.Add(DefaultTolerance + time.Second), ErrTimestampExpired},
+
+		// Slack
+		{"slack valid", slack, headers("X-Slack-Request-Timestamp", slackTimestamp, "X-Slack-Signature", slackSignature), slackBody, slackNow, nil},
+		{"slack tampered body", slack, headers("X-Slack-Request-Timestamp", slackTimestamp, "X-Slack-Signature", slackSignature), slackBody + "&x=1", slackNow, ErrInvalidSignature},
+		{"slack tampered timestamp", slack, headers("X-Slack-Request-Timestamp", "1531420619", "X-Slack-Signature", slackSignature), slackBody, slackNow, ErrInvalidSignature},
+		{"slack missing timestamp", slack, headers("X-Slack-Signature", slackSignature), slackBody, slackNow, ErrMissingSignature},
+		{"slack missing signature", slack, headers("X-Slack-Request-Timestamp", slackTimestamp), slackBody, slackNow, ErrMissingSignature},
+		{"slack wrong version", slack, headers("X-Slack-Request-Timestamp", slackTimestamp, "X-Slack-Signature", "v1="+slackSignature[3:]), slackBody, slackNow, ErrMissingSignature},
+		{"slack just inside tolerance", slack, headers("X-Slack-Request-Timestamp", slackTimestamp, "X-Slack-Signature", slackSignature), slackBody, slackNow.Add(-tolerance), nil},
+		{"slack just outside tolerance", slack, headers("X-Slack-Request-Timestamp", slackTimestamp, "X-Slack-Signature", slackSignature), slackBody, slackNow.Add(tolerance + time.Second), ErrTimestampExpired},
+
+		// Standard Webhooks and Svix
+		{"standard valid", standard, standardHeaders(standardSignature), standardBody, standardNow, nil},
+		{"standard one of several", standard, standardHeaders("v1,bm90IGl0 v1a,xyz " + standardSignature), standardBody, standardNow, nil},
+		{"standard only bad signatures", standard, standardHeaders("v1,bm90IGl0 v2," + standardSignature[3:]), standardBody, standardNow, ErrInvalidSignature},
+		{"standard not base64", standard, standardHeaders("v1,!!!"), standardBody, standardNow, ErrInvalidSignature},
+		{"standard no version", standard, standardHeaders(standardSignature[3:]), standardBody, standardNow, ErrInvalidSignature},
+		{"standard tampered body", standard, standardHeaders(standardSignature), standardBody + " ", standardNow, ErrInvalidSignature},
+		{"standard tampered id", standard, headers("Webhook-Id", "msg_other", "Webhook-Timestamp", standardTimestamp, "Webhook-Signature", standardSignature), standardBody, standardNow, ErrInvalidSignature},
+		{"standard missing id", standard, headers("Webhook-Timestamp", standardTimestamp, "Webhook-Signature", standardSignature), standardBody, standardNow, ErrMissingSignature},
+		{"standard missing signature", standard, headers("Webhook-Id", standardID, "Webhook-Timestamp", standardTimestamp), standardBody, standardNow, ErrMissingSignature},
+		{"standard just inside tolerance", standard, standardHeaders(standardSignature), standardBody, standardNow.Add(tolerance), nil},
+		{"standard just outside tolerance", standard, standardHeaders(standardSignature), standardBody, standardNow.Add(tolerance + time.Second), ErrTimestampExpired},
+		{"svix headers", svix, headers("Svix-Id", standardID, "Svix-Timestamp", standardTimestamp, "Svix-Signature", standardSignature), standardBody, standardNow, nil},
+		{"svix secret without prefix", &models.VerificationSettings{Scheme: SchemeSvix, Secret: standardSecret[len("whsec_"):]}, standardHeaders(standardSignature), standardBody, standardNow, nil},
+
+		// Generic HMAC
+		{"hmac sha256 hex", hmacHex, headers("X-Signature", "sha256="+hmacSHA256), hmacBody, time.Now(), nil},
+		{"hmac sha1", &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Algorithm: "sha1"}, headers("X-Signature", hmacSHA1), hmacBody, time.Now(), nil},
+		{"hmac sha512", &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Algorithm: "sha512"}, headers("X-Signature", hmacSHA512), hmacBody, time.Now(), nil},
+		{"hmac base64", &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Encoding: "base64"}, headers("X-Signature", hmacBase64), hmacBody, time.Now(), nil},
+		{"hmac base64 malformed", &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Encoding: "base64"}, headers("X-Signature", "%%%"), hmacBody, time.Now(), ErrInvalidSignature},
+		{"hmac wrong algorithm", &models.VerificationSettings{Scheme: SchemeHMAC, Secret: hmacSecret, Header: "X-Signature", Algorithm: "sha512"}, headers("X-Signature", hmacSHA256), hmacBody, time.Now(), ErrInvalidSignature},
+		{"hmac missing prefix", hmacHex, headers("X-Signature", hmacSHA256), hmacBody, time.Now(), ErrMissingSignature},
+		{"hmac missing header", hmacHex, headers("X-Other", "sha256="+hmacSHA256), hmacBody, time.Now(), ErrMissingSignature},
+		{"hmac tampered body", hmacHex, headers("X-Signature", "sha256="+hmacSHA256), hmacBody + "?", time.Now(), ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := New(tt.settings)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			err = v.Verify(tt.header, []byte(tt.body), tt.now)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBadSettings(t *testing.T) {
+	tests := map[string]*models.VerificationSettings{
+		"no secret":              {Scheme: SchemeGitHub},
+		"unknown scheme":         {Scheme: "carrier-pigeon", Secret: "s"},
+		"standard secret":        {Scheme: SchemeStandardWebhooks, Secret: "whsec_not base64!"},
+		"hmac without header":    {Scheme: SchemeHMAC, Secret: "s"},
+		"hmac unknown algorithm": {Scheme: SchemeHMAC, Secret: "s", Header: "X-Sig", Algorithm: "md5"},
+		"hmac unknown encoding":  {Scheme: SchemeHMAC, Secret: "s", Header: "X-Sig", Encoding: "base32"},
+	}
+	for name, settings := range tests {
+		if _, err := New(settings); err == nil {
+			t.Errorf("%s: New accepted %+v", name, settings)
+		}
+	}
+}
+
+func TestResultFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Result
+	}{
+		{nil, ResultValid},
+		{ErrMissingSignature, ResultMissing},
+		{ErrTimestampExpired, ResultExpired},
+		{ErrInvalidSignature, ResultInvalid},
+		{errors.New("anything else"), ResultInvalid},
+	}
+	for _, tt := range tests {
+		if got := ResultFor(tt.err); got != tt.want {
+			t.Errorf("ResultFor(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
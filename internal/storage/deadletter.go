@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+// A request that dies again after a redrive replaces its earlier entry.
+const insertDeadLetterSQL = `
+INSERT INTO dead_letters (request_id, project_name, reason, attempts, last_status,
+                          last_error, dead_at)
+VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''), $7)
+ON CONFLICT (request_id) DO UPDATE
+SET reason = EXCLUDED.reason,
+    attempts = EXCLUDED.attempts,
+    last_status = EXCLUDED.last_status,
+    last_error = EXCLUDED.last_error,
+    dead_at = EXCLUDED.dead_at`
+
+const deadLetterColumns = `
+request_id, project_name, reason, attempts, COALESCE(last_status, 0),
+COALESCE(last_error, ''), dead_at`
+
+// InsertDeadLetter moves a request to the dead-letter queue.
+func (ps *PostgresStore) InsertDeadLetter(ctx context.Context, dl *models.DeadLetter) error {
+	_, err := ps.pool.Exec(ctx, insertDeadLetterSQL,
+		dl.RequestID,
+		dl.ProjectName,
+		dl.Reason,
+		dl.Attempts,
+		dl.LastStatus,
+		dl.LastError,
+		dl.DeadAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+
+	return nil
+}
+
+func (ps *PostgresStore) GetDeadLetter(ctx context.Context, projectName, requestID string) (*models.DeadLetter, error) {
+	rows, err := ps.pool.Query(ctx,
+		`SELECT `+deadLetterColumns+` FROM dead_letters WHERE project_name = $1 AND request_id = $2`,
+		projectName, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letter: %w", err)
+	}
+
+	return collectDeadLetter(rows)
+}
+
+func (ps *PostgresStore) ListDeadLetters(ctx context.Context, filter models.DeadLetterFilter) ([]*models.DeadLetter, error) {
+	args := []any{filter.ProjectName}
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters WHERE project_name = $1`
+
+	if !filter.Before.IsZero() {
+		args = append(args, filter.Before)
+		query += fmt.Sprintf(" AND dead_at < $%d", len(args))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	args = append(args, min(limit, maxListLimit))
+	query += fmt.Sprintf(" ORDER BY dead_at DESC LIMIT $%d", len(args))
+
+	rows, err := ps.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+
+	dls, err := pgx.CollectRows(rows, scanDeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan dead letters: %w", err)
+	}
+
+	return dls, nil
+}
+
+// DeleteDeadLetter removes a dead letter and returns it, so that only one
+// caller can redrive it.
+func (ps *PostgresStore) DeleteDeadLetter(ctx context.Context, projectName, requestID string) (*models.DeadLetter, error) {
+	rows, err := ps.pool.Query(ctx,
+		`DELETE FROM dead_letters WHERE project_name = $1 AND request_id = $2 RETURNING `+deadLetterColumns,
+		projectName, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+
+	return collectDeadLetter(rows)
+}
+
+// CountDeadLetters returns the number of dead letters held for each project.
+func (ps *PostgresStore) CountDeadLetters(ctx context.Context) (map[string]int, error) {
+	rows, err := ps.pool.Query(ctx,
+		`SELECT project_name, COUNT(*) FROM dead_letters GROUP BY project_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			project string
+			count   int
+		)
+		if err := rows.Scan(&project, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter count: %w", err)
+		}
+		counts[project] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func collectDeadLetter(rows pgx.Rows) (*models.DeadLetter, error) {
+	dl, err := pgx.CollectOneRow(rows, scanDeadLetter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+	}
+
+	return dl, nil
+}
+
+func scanDeadLetter(row pgx.CollectableRow) (*models.DeadLetter, error) {
+	var dl models.DeadLetter
+	err := row.Scan(
+		&dl.RequestID,
+		&dl.ProjectName,
+		&dl.Reason,
+		&dl.Attempts,
+		&dl.LastStatus,
+		&dl.LastError,
+		&dl.DeadAt,
+	)
+	return &dl, err
+}
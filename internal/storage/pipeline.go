@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/whookdev/conductor/internal/config"
+	"github.com/whookdev/conductor/internal/metrics"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes Enqueue wait for room in the queue, bounded by the
+	// caller's context and the enqueue timeout.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest queued record to make room.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowSpill appends records that don't fit in the queue to a file on
+	// disk, which is replayed once the queue has drained.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+var ErrPipelineClosed = errors.New("storage pipeline is closed")
+
+var (
+	queueDepth = metrics.NewGauge("whook_storage_queue_depth",
+		"Number of records waiting to be written to storage.")
+	droppedRecords = metrics.NewCounterVec("whook_storage_dropped_total",
+		"Records discarded before reaching storage.", "reason")
+	spilledRecords = metrics.NewCounter("whook_storage_spilled_total",
+		"Records written to the on-disk spill file.")
+	writtenRecords = metrics.NewCounter("whook_storage_written_total",
+		"Records successfully written to storage.")
+	batchErrors = metrics.NewCounter("whook_storage_batch_errors_total",
+		"Batches that failed to write to storage.")
+)
+
+// Record is a single write handled by the pipeline: a stored request or a
+// delivery attempt made for one.
+type Record struct {
+	Request *models.StoredRequest   `json:"request,omitempty"`
+	Attempt *models.DeliveryAttempt `json:"attempt,omitempty"`
+}
+
+func (r Record) requestID() string {
+	if r.Request != nil {
+		return r.Request.ID
+	}
+	if r.Attempt != nil {
+		return r.Attempt.RequestID
+	}
+	return ""
+}
+
+// Writer persists a batch of records. Implementations must be safe to call
+// with records that may already have been written, as spilled batches are
+// replayed after failures.
+type Writer interface {
+	WriteBatch(ctx context.Context, records []Record) error
+}
+
+// Pipeline decouples request handling from storage latency by queueing
+// records in memory and writing them in batches from a single goroutine.
+type Pipeline struct {
+	writer        Writer
+	queue         chan Record
+	batchSize     int
+	flushInterval time.Duration
+	enqueueWait   time.Duration
+	policy        OverflowPolicy
+	spill         *spillFile
+	logger        *slog.Logger
+
+	mu        sync.RWMutex
+	closed    bool
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewPipeline(cfg *config.Config, writer Writer, logger *slog.Logger) (*Pipeline, error) {
+	if writer == nil {
+		return nil, fmt.Errorf("writer cannot be nil")
+	}
+	if cfg.StorageQueueSize <= 0 {
+		return nil, fmt.Errorf("storage queue size must be positive")
+	}
+	if cfg.StorageBatchSize <= 0 {
+		return nil, fmt.Errorf("storage batch size must be positive")
+	}
+
+	p := &Pipeline{
+		writer:        writer,
+		queue:         make(chan Record, cfg.StorageQueueSize),
+		batchSize:     cfg.StorageBatchSize,
+		flushInterval: cfg.StorageFlushInterval,
+		enqueueWait:   cfg.StorageEnqueueTimeout,
+		policy:        OverflowPolicy(cfg.StorageOverflowPolicy),
+		logger:        logger.With("component", "storage_pipeline"),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if cfg.StorageSpillDir != "" {
+		spill, err := newSpillFile(cfg.StorageSpillDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spill file: %w", err)
+		}
+		p.spill = spill
+	}
+
+	return p, nil
+}
+
+func (p *Pipeline) Start() {
+	p.logger.Info("starting storage pipeline",
+		"queue_size", cap(p.queue),
+		"batch_size", p.batchSize,
+		"flush_interval", p.flushInterval,
+		"enqueue_timeout", p.enqueueWait,
+		"overflow_policy", p.policy)
+
+	go p.run()
+}
+
+// Enqueue hands a record to the pipeline, applying the configured overflow
+// policy if the queue is full.
+func (p *Pipeline) Enqueue(ctx context.Context, rec Record) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPipelineClosed
+	}
+
+	select {
+	case p.queue <- rec:
+		queueDepth.Set(float64(len(p.queue)))
+		return nil
+	default:
+	}
+
+	switch p.policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case p.queue <- rec:
+				queueDepth.Set(float64(len(p.queue)))
+				return nil
+			default:
+			}
+
+			select {
+			case old := <-p.queue:
+				droppedRecords.WithLabelValues("overflow").Inc()
+				p.logger.Warn("storage queue full, dropped oldest record",
+					"request_id", old.requestID())
+			default:
+			}
+		}
+
+	case OverflowSpill:
+		if err := p.spill.Append(rec); err != nil {
+			droppedRecords.WithLabelValues("spill_error").Inc()
+			return fmt.Errorf("failed to spill record: %w", err)
+		}
+		spilledRecords.Inc()
+		return nil
+
+	default:
+		// Callers' contexts are often request contexts with no deadline of
+		// their own, so a stalled writer mustn't hold them forever.
+		timer := time.NewTimer(p.enqueueWait)
+		defer timer.Stop()
+
+		select {
+		case p.queue <- rec:
+			queueDepth.Set(float64(len(p.queue)))
+			return nil
+		case <-timer.C:
+			droppedRecords.WithLabelValues("timeout").Inc()
+			return fmt.Errorf("waiting for storage queue: timed out after %s", p.enqueueWait)
+		case <-ctx.Done():
+			droppedRecords.WithLabelValues("timeout").Inc()
+			return fmt.Errorf("waiting for storage queue: %w", ctx.Err())
+		case <-p.closing:
+			return ErrPipelineClosed
+		}
+	}
+}
+
+// Close stops accepting requests and flushes everything already queued. It
+// returns early with the context's error if flushing takes too long.
+func (p *Pipeline) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+
+		close(p.queue)
+	})
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flushing storage pipeline: %w", ctx.Err())
+	}
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, p.batchSize)
+
+	for {
+		select {
+		case rec, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				p.logger.Info("storage pipeline drained")
+				return
+			}
+
+			batch = append(batch, rec)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = batch[:0]
+			} else if p.spill != nil && len(p.queue) == 0 {
+				p.replaySpill()
+			}
+		}
+	}
+}
+
+func (p *Pipeline) flush(batch []Record) {
+	queueDepth.Set(float64(len(p.queue)))
+
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := p.writer.WriteBatch(ctx, batch); err != nil {
+		batchErrors.Inc()
+		p.logger.Error("failed to write batch", "size", len(batch), "error", err)
+
+		if p.spill == nil {
+			droppedRecords.WithLabelValues("write_error").Add(uint64(len(batch)))
+			return
+		}
+
+		for _, rec := range batch {
+			if err := p.spill.Append(rec); err != nil {
+				droppedRecords.WithLabelValues("spill_error").Inc()
+				p.logger.Error("failed to spill record", "request_id", rec.requestID(), "error", err)
+				continue
+			}
+			spilledRecords.Inc()
+		}
+		return
+	}
+
+	writtenRecords.Add(uint64(len(batch)))
+	p.logger.Debug("wrote batch", "size", len(batch), "duration", time.Since(start))
+}
+
+func (p *Pipeline) replaySpill() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n, err := p.spill.Replay(ctx, p.batchSize, p.writer.WriteBatch)
+	if n > 0 {
+		writtenRecords.Add(uint64(n))
+		p.logger.Info("replayed spilled records", "count", n)
+	}
+	if err != nil {
+		p.logger.Error("failed to replay spilled records", "error", err)
+	}
+}
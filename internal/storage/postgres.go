@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/whookdev/conductor/internal/models"
+)
+
+var (
+	ErrRequestNotFound    = errors.New("request not found")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+const insertRequestSQL = `
+INSERT INTO requests (id, project_name, method, path, headers, body, received_at,
+                      idempotency_key, duplicate_of, provider, event_type, delivery_id,
+                      signature_status, query, correlation_id, request_uri, host, remote_addr,
+                      header_values)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''),
+        NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''),
+        NULLIF($15, ''), NULLIF($16, ''), NULLIF($17, ''), NULLIF($18, ''), $19)
+ON CONFLICT (id) DO NOTHING`
+
+const selectRequestColumns = `
+SELECT id, project_name, method, path, headers, body, received_at,
+       COALESCE(idempotency_key, ''), COALESCE(duplicate_of, ''),
+       COALESCE(provider, ''), COALESCE(event_type, ''), COALESCE(delivery_id, ''),
+       COALESCE(signature_status, ''), COALESCE(query, ''), COALESCE(correlation_id, ''),
+       COALESCE(request_uri, ''), COALESCE(host, ''), COALESCE(remote_addr, ''), header_values
+FROM requests`
+
+const insertAttemptSQL = `
+INSERT INTO delivery_attempts (request_id, attempt, project_name, mode, relay_id,
+                               started_at, duration_ms, status_code, error)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, 0), NULLIF($9, ''))
+ON CONFLICT (request_id, attempt) DO NOTHING`
+
+const selectAttemptsSQL = `
+SELECT request_id, attempt, project_name, mode, COALESCE(relay_id, ''),
+       started_at, duration_ms, COALESCE(status_code, 0), COALESCE(error, '')
+FROM delivery_attempts
+WHERE request_id = $1
+ORDER BY attempt`
+
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (ps *PostgresStore) WriteBatch(ctx context.Context, records []Record) error {
+	batch := &pgx.Batch{}
+	for _, rec := range records {
+		if a := rec.Attempt; a != nil {
+			batch.Queue(insertAttemptSQL,
+				a.RequestID,
+				a.Attempt,
+				a.ProjectName,
+				a.Mode,
+				a.RelayID,
+				a.StartedAt,
+				a.DurationMs,
+				a.StatusCode,
+				a.Error,
+			)
+		}
+
+		req := rec.Request
+		if req == nil {
+			continue
+		}
+		batch.Queue(insertRequestSQL,
+			req.ID,
+			req.ProjectName,
+			req.Method,
+			req.Path,
+			req.Headers,
+			req.Body,
+			req.ReceivedAt,
+			req.IdempotencyKey,
+			req.DuplicateOf,
+			req.Provider,
+			req.EventType,
+			req.DeliveryID,
+			req.SignatureStatus,
+			req.Query,
+			req.CorrelationID,
+			req.RequestURI,
+			req.Host,
+			req.RemoteAddr,
+			req.HeaderValues,
+		)
+	}
+
+	if err := ps.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to insert records: %w", err)
+	}
+
+	return nil
+}
+
+func (ps *PostgresStore) GetRequest(ctx context.Context, projectName, id string) (*models.StoredRequest, error) {
+	rows, err := ps.pool.Query(ctx,
+		selectRequestColumns+` WHERE project_name = $1 AND id = $2`,
+		projectName, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request: %w", err)
+	}
+
+	req, err := pgx.CollectOneRow(rows, scanRequest)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, fmt.Errorf("failed to scan request: %w", err)
+	}
+
+	return req, nil
+}
+
+func (ps *PostgresStore) ListRequests(ctx context.Context, filter models.RequestFilter) ([]*models.StoredRequest, error) {
+	var (
+		conditions = []string{"project_name = $1"}
+		args       = []any{filter.ProjectName}
+	)
+
+	addCondition := func(column string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s $%d", column, len(args)))
+	}
+
+	if filter.Provider != "" {
+		addCondition("provider =", filter.Provider)
+	}
+	if filter.EventType != "" {
+		addCondition("event_type =", filter.EventType)
+	}
+	if filter.DeliveryID != "" {
+		addCondition("delivery_id =", filter.DeliveryID)
+	}
+	if !filter.Before.IsZero() {
+		addCondition("received_at <", filter.Before)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+	args = append(args, limit)
+
+	query := fmt.Sprintf("%s WHERE %s ORDER BY received_at DESC LIMIT $%d",
+		selectRequestColumns, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := ps.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query requests: %w", err)
+	}
+
+	reqs, err := pgx.CollectRows(rows, scanRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan requests: %w", err)
+	}
+
+	return reqs, nil
+}
+
+func (ps *PostgresStore) ListAttempts(ctx context.Context, requestID string) ([]*models.DeliveryAttempt, error) {
+	rows, err := ps.pool.Query(ctx, selectAttemptsSQL, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query delivery attempts: %w", err)
+	}
+
+	attempts, err := pgx.CollectRows(rows, scanAttempt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan delivery attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func scanAttempt(row pgx.CollectableRow) (*models.DeliveryAttempt, error) {
+	var a models.DeliveryAttempt
+	err := row.Scan(
+		&a.RequestID,
+		&a.Attempt,
+		&a.ProjectName,
+		&a.Mode,
+		&a.RelayID,
+		&a.StartedAt,
+		&a.DurationMs,
+		&a.StatusCode,
+		&a.Error,
+	)
+	return &a, err
+}
+
+func scanRequest(row pgx.CollectableRow) (*models.StoredRequest, error) {
+	var req models.StoredRequest
+	err := row.Scan(
+		&req.ID,
+		&req.ProjectName,
+		&req.Method,
+		&req.Path,
+		&req.Headers,
+		&req.Body,
+		&req.ReceivedAt,
+		&req.IdempotencyKey,
+		&req.DuplicateOf,
+		&req.Provider,
+		&req.EventType,
+		&req.DeliveryID,
+		&req.SignatureStatus,
+		&req.Query,
+		&req.CorrelationID,
+		&req.RequestURI,
+		&req.Host,
+		&req.RemoteAddr,
+		&req.HeaderValues,
+	)
+	return &req, err
+}
@@ -2,26 +2,41 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/whookdev/conductor/internal/metrics"
 	"github.com/whookdev/conductor/internal/models"
+	"github.com/whookdev/conductor/internal/providers"
+	"github.com/whookdev/conductor/internal/requestid"
 )
 
+var deadLetterDepth = metrics.NewGaugeVec("whook_dead_letters",
+	"Webhooks waiting in the dead-letter queue.", "project")
+
 type RequestStorage struct {
-	logger *slog.Logger
+	pipeline  *Pipeline
+	store     *PostgresStore
+	detectors *providers.Registry
+	logger    *slog.Logger
 }
 
-func New(logger *slog.Logger) *RequestStorage {
+func New(pipeline *Pipeline, store *PostgresStore, detectors *providers.Registry, logger *slog.Logger) *RequestStorage {
 	return &RequestStorage{
-		logger: logger.With("component", "request_storage"),
+		pipeline:  pipeline,
+		store:     store,
+		detectors: detectors,
+		logger:    logger.With("component", "request_storage"),
 	}
 }
 
-func (s *RequestStorage) StoreRequest(r *http.Request, projectName string) (*models.StoredRequest, error) {
+// Capture reads the request body, leaving a fresh reader in its place so the
+// request can still be forwarded, and builds the record to be stored.
+func (s *RequestStorage) Capture(r *http.Request, projectName string) (*models.StoredRequest, error) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read request body: %w", err)
@@ -34,21 +49,166 @@ func (s *RequestStorage) StoreRequest(r *http.Request, projectName string) (*mod
 		headers[name] = values[0]
 	}
 
+	// The server assigns the ID as the request arrives so it is already on
+	// every log line; anything else gets one here.
+	id := requestid.FromContext(r.Context())
+	if id == "" {
+		id = requestid.New()
+	}
+
 	storedReq := &models.StoredRequest{
-		Method:      r.Method,
-		Path:        r.URL.Path,
-		Headers:     headers,
-		Body:        bodyBytes,
-		ProjectName: projectName,
-		ReceivedAt:  time.Now(),
+		ID:           id,
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Query:        r.URL.RawQuery,
+		Headers:      headers,
+		RequestURI:   r.RequestURI,
+		Host:         r.Host,
+		RemoteAddr:   r.RemoteAddr,
+		HeaderValues: r.Header.Clone(),
+		Body:         bodyBytes,
+		ProjectName:  projectName,
+		ReceivedAt:   time.Now(),
+		// A trusted proxy's own ID, kept so its logs can be matched up.
+		CorrelationID: requestid.CorrelationFromContext(r.Context()),
 	}
 
-	s.logger.Info("stored request",
-		"project", projectName,
+	if detection, ok := s.detectors.Detect(r.Header, bodyBytes); ok {
+		storedReq.Provider = detection.Provider
+		storedReq.EventType = detection.EventType
+		storedReq.DeliveryID = detection.DeliveryID
+	}
+
+	return storedReq, nil
+}
+
+func (s *RequestStorage) Store(ctx context.Context, storedReq *models.StoredRequest) error {
+	if err := s.pipeline.Enqueue(ctx, Record{Request: storedReq}); err != nil {
+		return fmt.Errorf("failed to queue request: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "stored request",
+		"request_id", storedReq.ID,
+		"project", storedReq.ProjectName,
 		"method", storedReq.Method,
 		"path", storedReq.Path,
 		"received_at", storedReq.ReceivedAt,
+		"duplicate_of", storedReq.DuplicateOf,
+		"provider", storedReq.Provider,
+		"event_type", storedReq.EventType,
 	)
 
-	return storedReq, nil
+	return nil
+}
+
+// RecordAttempt queues a delivery attempt to be written alongside the
+// request it was made for.
+func (s *RequestStorage) RecordAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error {
+	if err := s.pipeline.Enqueue(ctx, Record{Attempt: attempt}); err != nil {
+		return fmt.Errorf("failed to queue delivery attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RequestStorage) GetRequest(ctx context.Context, projectName, id string) (*models.StoredRequest, error) {
+	req, err := s.store.GetRequest(ctx, projectName, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Attempts, err = s.store.ListAttempts(ctx, req.ID); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *RequestStorage) ListRequests(ctx context.Context, filter models.RequestFilter) ([]*models.StoredRequest, error) {
+	return s.store.ListRequests(ctx, filter)
+}
+
+// DeadLetter moves a request to the dead-letter queue. Unlike requests and
+// attempts it is written straight away rather than through the pipeline, so
+// a redrive that follows can always find it, and one that has already taken
+// it can't have it reappear from a later flush.
+func (s *RequestStorage) DeadLetter(ctx context.Context, dl *models.DeadLetter) error {
+	if err := s.store.InsertDeadLetter(ctx, dl); err != nil {
+		return err
+	}
+
+	deadLetterDepth.WithLabelValues(dl.ProjectName).Add(1)
+	s.logger.WarnContext(ctx, "moved request to dead-letter queue",
+		"request_id", dl.RequestID,
+		"project", dl.ProjectName,
+		"reason", dl.Reason,
+		"attempts", dl.Attempts)
+
+	return nil
+}
+
+func (s *RequestStorage) ListDeadLetters(ctx context.Context, filter models.DeadLetterFilter) ([]*models.DeadLetter, error) {
+	return s.store.ListDeadLetters(ctx, filter)
+}
+
+// GetDeadLetter returns a dead letter along with the request it holds.
+func (s *RequestStorage) GetDeadLetter(ctx context.Context, projectName, requestID string) (*models.DeadLetter, error) {
+	dl, err := s.store.GetDeadLetter(ctx, projectName, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if dl.Request, err = s.GetRequest(ctx, projectName, requestID); err != nil {
+		return nil, err
+	}
+
+	return dl, nil
+}
+
+// TakeDeadLetter removes a dead letter from the queue and returns it.
+func (s *RequestStorage) TakeDeadLetter(ctx context.Context, projectName, requestID string) (*models.DeadLetter, error) {
+	dl, err := s.store.DeleteDeadLetter(ctx, projectName, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetterDepth.WithLabelValues(projectName).Add(-1)
+	return dl, nil
+}
+
+// WatchDeadLetters keeps the dead-letter depth metric in line with the
+// database, which other instances also write to.
+func (s *RequestStorage) WatchDeadLetters(ctx context.Context, interval time.Duration) {
+	refresh := func() {
+		counts, err := s.store.CountDeadLetters(ctx)
+		if err != nil {
+			s.logger.Error("failed to count dead letters", "error", err)
+			return
+		}
+
+		deadLetterDepth.Reset()
+		for project, count := range counts {
+			deadLetterDepth.WithLabelValues(project).Set(float64(count))
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		refresh()
+		for {
+			select {
+			case <-ticker.C:
+				refresh()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close flushes any requests still waiting in the pipeline.
+func (s *RequestStorage) Close(ctx context.Context) error {
+	return s.pipeline.Close(ctx)
 }
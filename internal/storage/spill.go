@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/whookdev/conductor/internal/models"
+)
+
+const (
+	spillFileName  = "requests.spill"
+	replayFileName = "requests.replay"
+)
+
+// spillFile is an append-only JSON lines file holding records that couldn't
+// be queued or written. Replay moves the file aside before reading so new
+// spills can continue while older ones are written out.
+type spillFile struct {
+	mu         sync.Mutex
+	path       string
+	replayPath string
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &spillFile{
+		path:       filepath.Join(dir, spillFileName),
+		replayPath: filepath.Join(dir, replayFileName),
+	}, nil
+}
+
+func (s *spillFile) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Replay writes spilled records in batches and removes them from disk once
+// every batch has succeeded. A failed replay leaves the remaining records in
+// place to be retried later.
+func (s *spillFile) Replay(ctx context.Context, batchSize int, write func(context.Context, []Record) error) (int, error) {
+	s.mu.Lock()
+	if _, err := os.Stat(s.replayPath); errors.Is(err, fs.ErrNotExist) {
+		if err := os.Rename(s.path, s.replayPath); err != nil {
+			s.mu.Unlock()
+			if errors.Is(err, fs.ErrNotExist) {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("failed to move spill file aside: %w", err)
+		}
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(s.replayPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		written int
+		batch   = make([]Record, 0, batchSize)
+		scanner = bufio.NewScanner(f)
+	)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A torn write from a crash mid-append; nothing to recover.
+			continue
+		}
+
+		if rec.Request == nil && rec.Attempt == nil {
+			// Spill files written before attempts were recorded hold bare
+			// requests.
+			var req models.StoredRequest
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.ID == "" {
+				continue
+			}
+			rec.Request = &req
+		}
+
+		batch = append(batch, rec)
+		if len(batch) >= batchSize {
+			if err := write(ctx, batch); err != nil {
+				return written, err
+			}
+			written += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return written, fmt.Errorf("failed to read replay file: %w", err)
+	}
+
+	if len(batch) > 0 {
+		if err := write(ctx, batch); err != nil {
+			return written, err
+		}
+		written += len(batch)
+	}
+
+	if err := os.Remove(s.replayPath); err != nil {
+		return written, fmt.Errorf("failed to remove replay file: %w", err)
+	}
+
+	return written, nil
+}
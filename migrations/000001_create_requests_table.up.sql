@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS requests (
+    id           TEXT PRIMARY KEY,
+    project_name TEXT        NOT NULL,
+    method       TEXT        NOT NULL,
+    path         TEXT        NOT NULL,
+    headers      JSONB       NOT NULL DEFAULT '{}'::jsonb,
+    body         BYTEA,
+    received_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS requests_project_received_at_idx
+    ON requests (project_name, received_at DESC);
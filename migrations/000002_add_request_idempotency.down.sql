@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS requests_project_idempotency_key_idx;
+DROP INDEX IF EXISTS requests_duplicate_of_idx;
+
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS duplicate_of,
+    DROP COLUMN IF EXISTS idempotency_key;
@@ -0,0 +1,11 @@
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS idempotency_key TEXT,
+    ADD COLUMN IF NOT EXISTS duplicate_of    TEXT;
+
+CREATE INDEX IF NOT EXISTS requests_duplicate_of_idx
+    ON requests (duplicate_of)
+    WHERE duplicate_of IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS requests_project_idempotency_key_idx
+    ON requests (project_name, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS requests_project_delivery_id_idx;
+DROP INDEX IF EXISTS requests_project_provider_event_idx;
+
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS delivery_id,
+    DROP COLUMN IF EXISTS event_type,
+    DROP COLUMN IF EXISTS provider;
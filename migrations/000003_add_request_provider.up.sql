@@ -0,0 +1,11 @@
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS provider    TEXT,
+    ADD COLUMN IF NOT EXISTS event_type  TEXT,
+    ADD COLUMN IF NOT EXISTS delivery_id TEXT;
+
+CREATE INDEX IF NOT EXISTS requests_project_provider_event_idx
+    ON requests (project_name, provider, event_type, received_at DESC);
+
+CREATE INDEX IF NOT EXISTS requests_project_delivery_id_idx
+    ON requests (project_name, delivery_id)
+    WHERE delivery_id IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS signature_status;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS delivery_attempts (
+    request_id   TEXT        NOT NULL,
+    attempt      INTEGER     NOT NULL,
+    project_name TEXT        NOT NULL,
+    mode         TEXT        NOT NULL,
+    relay_id     TEXT,
+    started_at   TIMESTAMPTZ NOT NULL,
+    duration_ms  BIGINT      NOT NULL,
+    status_code  INTEGER,
+    error        TEXT,
+    PRIMARY KEY (request_id, attempt)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+    request_id   TEXT PRIMARY KEY,
+    project_name TEXT        NOT NULL,
+    reason       TEXT        NOT NULL,
+    attempts     INTEGER     NOT NULL,
+    last_status  INTEGER,
+    last_error   TEXT,
+    dead_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_project_dead_at_idx
+    ON dead_letters (project_name, dead_at DESC);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tls_certificates (
+    name       TEXT PRIMARY KEY,
+    cert_pem   TEXT        NOT NULL,
+    key_pem    TEXT        NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS query TEXT;
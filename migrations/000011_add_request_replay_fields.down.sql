@@ -0,0 +1,5 @@
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS request_uri,
+    DROP COLUMN IF EXISTS host,
+    DROP COLUMN IF EXISTS remote_addr,
+    DROP COLUMN IF EXISTS header_values;
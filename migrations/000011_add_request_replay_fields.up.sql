@@ -0,0 +1,5 @@
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS request_uri TEXT,
+    ADD COLUMN IF NOT EXISTS host TEXT,
+    ADD COLUMN IF NOT EXISTS remote_addr TEXT,
+    ADD COLUMN IF NOT EXISTS header_values JSONB;
@@ -0,0 +1,208 @@
+// Package relayauth signs requests forwarded from a conductor to a relay and
+// lets relays verify them, so a relay can tell conductor traffic apart from
+// anyone who has found its URL.
+//
+// A signature covers the method, request URI, a Unix timestamp and a SHA-256
+// digest of the body, keyed with an HMAC secret shared between the conductor
+// and its relays. Secrets are identified by a key ID so they can be rotated:
+// the conductor signs with its active key while relays accept any key in
+// their keyring.
+//
+// In the other direction, assignment tokens let a relay check that the CLI
+// connecting to it was assigned the project it claims. They are JWTs signed
+// with Ed25519 (alg EdDSA), so relays only need the conductor's public keys,
+// which it publishes as a JWKS document.
+package relayauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderSignature     = "X-Whook-Signature"
+	HeaderTimestamp     = "X-Whook-Timestamp"
+	HeaderKeyID         = "X-Whook-Key-Id"
+	HeaderContentDigest = "X-Whook-Content-Digest"
+)
+
+// DefaultTolerance is the clock skew a Verifier accepts unless told otherwise.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("relayauth: request is not signed")
+	ErrUnknownKey       = errors.New("relayauth: unknown signing key")
+	ErrDigestMismatch   = errors.New("relayauth: body does not match digest")
+	ErrInvalidSignature = errors.New("relayauth: invalid signature")
+	ErrExpired          = errors.New("relayauth: timestamp outside tolerance")
+)
+
+// Keyring holds the secrets known to a signer or verifier, along with the ID
+// of the key used for signing.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+func NewKeyring(activeID string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("relayauth: keyring requires at least one key")
+	}
+	if activeID == "" && len(keys) == 1 {
+		for id := range keys {
+			activeID = id
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("relayauth: active key %q not in keyring", activeID)
+	}
+
+	kr := &Keyring{active: activeID, keys: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("relayauth: key %q is empty", id)
+		}
+		kr.keys[id] = secret
+	}
+
+	return kr, nil
+}
+
+// ParseKeyring builds a keyring from a comma-separated list of id:secret
+// pairs, as used in environment variables.
+func ParseKeyring(spec, activeID string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("relayauth: malformed key entry %q", entry)
+		}
+		keys[id] = []byte(secret)
+	}
+
+	return NewKeyring(activeID, keys)
+}
+
+// ActiveKeyID returns the ID of the key used for signing.
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.active
+}
+
+type Signer struct {
+	keyring *Keyring
+}
+
+func NewSigner(kr *Keyring) *Signer {
+	return &Signer{keyring: kr}
+}
+
+// Sign sets the signature headers on an outgoing request. body must be the
+// exact bytes that will be sent.
+func (s *Signer) Sign(req *http.Request, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	digest := Digest(body)
+	keyID := s.keyring.active
+
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderContentDigest, digest)
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderSignature, "v1="+sign(s.keyring.keys[keyID],
+		req.Method, req.URL.RequestURI(), timestamp, digest))
+}
+
+type Verifier struct {
+	keyring   *Keyring
+	tolerance time.Duration
+}
+
+func NewVerifier(kr *Keyring, tolerance time.Duration) *Verifier {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	return &Verifier{keyring: kr, tolerance: tolerance}
+}
+
+// Verify checks the signature on an inbound request against its body.
+func (v *Verifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	keyID := r.Header.Get(HeaderKeyID)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	digest := r.Header.Get(HeaderContentDigest)
+	sig, ok := strings.CutPrefix(r.Header.Get(HeaderSignature), "v1=")
+	if keyID == "" || timestamp == "" || digest == "" || !ok {
+		return ErrMissingSignature
+	}
+
+	secret, ok := v.keyring.keys[keyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if drift := now.Sub(time.Unix(seconds, 0)); drift > v.tolerance || drift < -v.tolerance {
+		return ErrExpired
+	}
+
+	if !hmac.Equal([]byte(digest), []byte(Digest(body))) {
+		return ErrDigestMismatch
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	expected := sign(secret, r.Method, uri, timestamp, digest)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Middleware rejects requests that fail verification with a 401. The body is
+// buffered for verification and restored for the next handler.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := v.Verify(r, body, time.Now()); err != nil {
+			http.Error(w, "Invalid conductor signature", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Digest returns the value of the content digest header for body.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func sign(secret []byte, method, uri, timestamp, digest string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "v1\n%s\n%s\n%s\n%s", method, uri, timestamp, digest)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,226 @@
+package relayauth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, activeID string, keys map[string]string) *Keyring {
+	t.Helper()
+
+	secrets := make(map[string][]byte, len(keys))
+	for id, secret := range keys {
+		secrets[id] = []byte(secret)
+	}
+	kr, err := NewKeyring(activeID, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	signer := NewSigner(testKeyring(t, "k2", map[string]string{"k2": "new-secret"}))
+	// The relay still knows the retired key, as it would mid-rotation.
+	verifier := NewVerifier(testKeyring(t, "k1", map[string]string{"k1": "old-secret", "k2": "new-secret"}), 0)
+
+	relay := httptest.NewServer(verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})))
+	defer relay.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		opaque string
+		query  string
+		body   string
+	}{
+		{"plain path", http.MethodPost, "/hook", "", `{"event":"ping"}`},
+		{"query", http.MethodPost, "/hook", "b=2&a=1", `{}`},
+		{"encoded characters", http.MethodPost, "/hook/a%2Fb;v=1/%7Euser", "x=%7e&y=a+b&y=%41&z", `{}`},
+		{"empty body", http.MethodGet, "/hook", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, relay.URL, bytes.NewReader([]byte(tt.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Opaque keeps the URI exactly as written, the way the
+			// conductor forwards a sender's request.
+			req.URL.Opaque = tt.opaque
+			req.URL.RawQuery = tt.query
+
+			signer.Sign(req, []byte(tt.body), time.Now())
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			echoed, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, body = %q", resp.StatusCode, echoed)
+			}
+			if string(echoed) != tt.body {
+				t.Fatalf("relay handler read body %q, want %q", echoed, tt.body)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	keys := map[string]string{"k1": "secret"}
+	signer := NewSigner(testKeyring(t, "k1", keys))
+	verifier := NewVerifier(testKeyring(t, "k1", keys), time.Minute)
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"ping"}`)
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		tamper   func(r *http.Request) []byte
+		verifyAt time.Time
+		want     error
+	}{
+		{
+			name:   "untouched",
+			tamper: func(r *http.Request) []byte { return body },
+		},
+		{
+			name:   "tampered body",
+			tamper: func(r *http.Request) []byte { return []byte(`{"event":"pong"}`) },
+			want:   ErrDigestMismatch,
+		},
+		{
+			name: "tampered body with a matching digest",
+			tamper: func(r *http.Request) []byte {
+				forged := []byte(`{"event":"pong"}`)
+				r.Header.Set(HeaderContentDigest, Digest(forged))
+				return forged
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "tampered timestamp",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()+1, 10))
+				return body
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "malformed timestamp",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set(HeaderTimestamp, "soon")
+				return body
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "tampered signature",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set(HeaderSignature, "v1=AAAA"+r.Header.Get(HeaderSignature)[7:])
+				return body
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "tampered method",
+			tamper: func(r *http.Request) []byte {
+				r.Method = http.MethodPut
+				return body
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "tampered uri",
+			tamper: func(r *http.Request) []byte {
+				r.URL.RawQuery = "project=other"
+				return body
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "unknown key id",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set(HeaderKeyID, "k9")
+				return body
+			},
+			want: ErrUnknownKey,
+		},
+		{
+			name: "missing signature",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Del(HeaderSignature)
+				return body
+			},
+			want: ErrMissingSignature,
+		},
+		{
+			name: "unversioned signature",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set(HeaderSignature, r.Header.Get(HeaderSignature)[3:])
+				return body
+			},
+			want: ErrMissingSignature,
+		},
+		{
+			name:     "wrong key",
+			verifier: NewVerifier(testKeyring(t, "k1", map[string]string{"k1": "other-secret"}), time.Minute),
+			tamper:   func(r *http.Request) []byte { return body },
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "edge of tolerance",
+			tamper:   func(r *http.Request) []byte { return body },
+			verifyAt: now.Add(time.Minute),
+		},
+		{
+			name:     "expired",
+			tamper:   func(r *http.Request) []byte { return body },
+			verifyAt: now.Add(time.Minute + time.Second),
+			want:     ErrExpired,
+		},
+		{
+			name:     "from the future",
+			tamper:   func(r *http.Request) []byte { return body },
+			verifyAt: now.Add(-time.Minute - time.Second),
+			want:     ErrExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/hook?x=%7e", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer.Sign(req, body, now)
+
+			got := tt.tamper(req)
+
+			v := verifier
+			if tt.verifier != nil {
+				v = tt.verifier
+			}
+			at := now
+			if !tt.verifyAt.IsZero() {
+				at = tt.verifyAt
+			}
+
+			if err := v.Verify(req, got, at); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package relayauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultTokenLeeway is the clock skew a TokenVerifier accepts on expiry.
+const DefaultTokenLeeway = 30 * time.Second
+
+const tokenAlgorithm = "EdDSA"
+
+var (
+	ErrMalformedToken = errors.New("relayauth: malformed assignment token")
+	ErrTokenExpired   = errors.New("relayauth: assignment token expired")
+	ErrWrongRelay     = errors.New("relayauth: assignment token is for another relay")
+)
+
+// AssignmentClaims bind a token to a project (sub), the relay it was
+// assigned to (aud) and the assignment generation (gen). Relays should
+// reject a token whose generation is lower than one they have already seen
+// for the project, so a token from a superseded assignment can't be replayed
+// before it expires.
+type AssignmentClaims struct {
+	Project    string `json:"sub"`
+	RelayID    string `json:"aud"`
+	Generation int64  `json:"gen"`
+	IssuedAt   int64  `json:"iat"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// JWK is an Ed25519 public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS is the document relays fetch to verify assignment tokens.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// TokenKeyring holds the Ed25519 keys a conductor signs assignment tokens
+// with. Every key is published, so relays keep accepting tokens signed with
+// a key that is being rotated out.
+type TokenKeyring struct {
+	active string
+	keys   map[string]ed25519.PrivateKey
+}
+
+func NewTokenKeyring(activeID string, keys map[string]ed25519.PrivateKey) (*TokenKeyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("relayauth: token keyring requires at least one key")
+	}
+	if activeID == "" && len(keys) == 1 {
+		for id := range keys {
+			activeID = id
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("relayauth: active token key %q not in keyring", activeID)
+	}
+
+	kr := &TokenKeyring{active: activeID, keys: make(map[string]ed25519.PrivateKey, len(keys))}
+	for id, key := range keys {
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("relayauth: token key %q is not an Ed25519 key", id)
+		}
+		kr.keys[id] = key
+	}
+
+	return kr, nil
+}
+
+// ParseTokenKeyring builds a keyring from a comma-separated list of id:seed
+// pairs, where each seed is a base64-encoded 32-byte Ed25519 seed.
+func ParseTokenKeyring(spec, activeID string) (*TokenKeyring, error) {
+	keys := make(map[string]ed25519.PrivateKey)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("relayauth: malformed token key entry %q", entry)
+		}
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("relayauth: token key %q must be a base64-encoded %d-byte seed", id, ed25519.SeedSize)
+		}
+		keys[id] = ed25519.NewKeyFromSeed(seed)
+	}
+
+	return NewTokenKeyring(activeID, keys)
+}
+
+// JWKS returns the public half of every key in the keyring.
+func (kr *TokenKeyring) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(kr.keys))}
+	for id, key := range kr.keys {
+		jwks.Keys = append(jwks.Keys, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			Kid: id,
+			Use: "sig",
+			Alg: tokenAlgorithm,
+		})
+	}
+	slices.SortFunc(jwks.Keys, func(a, b JWK) int { return strings.Compare(a.Kid, b.Kid) })
+	return jwks
+}
+
+type TokenSigner struct {
+	keyring *TokenKeyring
+	ttl     time.Duration
+}
+
+func NewTokenSigner(kr *TokenKeyring, ttl time.Duration) *TokenSigner {
+	return &TokenSigner{keyring: kr, ttl: ttl}
+}
+
+// Keyring returns the keys the signer publishes.
+func (s *TokenSigner) Keyring() *TokenKeyring {
+	return s.keyring
+}
+
+// Sign issues a token for an assignment and returns it with its expiry.
+func (s *TokenSigner) Sign(project, relayID string, generation int64, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(s.ttl)
+	header, err := json.Marshal(tokenHeader{Alg: tokenAlgorithm, Typ: "JWT", Kid: s.keyring.active})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("relayauth: encoding token header: %w", err)
+	}
+	claims, err := json.Marshal(AssignmentClaims{
+		Project:    project,
+		RelayID:    relayID,
+		Generation: generation,
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("relayauth: encoding token claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig := ed25519.Sign(s.keyring.keys[s.keyring.active], []byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), time.Unix(expiresAt.Unix(), 0), nil
+}
+
+// TokenVerifier checks assignment tokens on a relay.
+type TokenVerifier struct {
+	keys   map[string]ed25519.PublicKey
+	leeway time.Duration
+}
+
+// NewTokenVerifier trusts the Ed25519 keys in jwks. Keys of any other type
+// are ignored.
+func NewTokenVerifier(jwks JWKS, leeway time.Duration) (*TokenVerifier, error) {
+	if leeway <= 0 {
+		leeway = DefaultTokenLeeway
+	}
+
+	v := &TokenVerifier{keys: make(map[string]ed25519.PublicKey), leeway: leeway}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("relayauth: key %q is not a valid Ed25519 public key", jwk.Kid)
+		}
+		v.keys[jwk.Kid] = key
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("relayauth: JWKS contains no Ed25519 keys")
+	}
+
+	return v, nil
+}
+
+// Verify checks a token's signature and expiry and that it was issued for
+// relayID, and returns its claims.
+func (v *TokenVerifier) Verify(token, relayID string, now time.Time) (*AssignmentClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != tokenAlgorithm {
+		return nil, ErrMalformedToken
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	var claims AssignmentClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.RelayID != relayID {
+		return nil, ErrWrongRelay
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+// FetchJWKS downloads a conductor's published keys, for building a
+// TokenVerifier. Relays should refetch periodically to pick up new keys.
+func FetchJWKS(ctx context.Context, client *http.Client, url string) (JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return JWKS{}, fmt.Errorf("relayauth: building JWKS request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return JWKS{}, fmt.Errorf("relayauth: fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return JWKS{}, fmt.Errorf("relayauth: fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&jwks); err != nil {
+		return JWKS{}, fmt.Errorf("relayauth: decoding JWKS: %w", err)
+	}
+	return jwks, nil
+}